@@ -0,0 +1,42 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+
+	"gopkg.in/yaml.v3"
+)
+
+// openapi.yaml is generated from helloworld.proto by protoc-gen-openapi.
+//
+//go:embed openapi.yaml
+var openAPIYAML []byte
+
+//go:embed docs.html
+var docsHTML []byte
+
+// openAPIJSON converts the generated OpenAPI document to JSON.
+func openAPIJSON() ([]byte, error) {
+	var doc map[string]any
+	if err := yaml.Unmarshal(openAPIYAML, &doc); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(doc)
+}
+
+// OpenAPI serves the OpenAPI document of the gateway routes.
+func OpenAPI(spec []byte) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(spec)
+	}
+}
+
+// Docs serves a browsable rendering of /openapi.json. The page is
+// self-contained so it works without access to a CDN.
+func Docs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(docsHTML)
+}
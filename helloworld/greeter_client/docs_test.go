@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenAPI(t *testing.T) {
+	spec, err := openAPIJSON()
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	OpenAPI(spec)(w, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	var doc struct {
+		OpenAPI    string                                `json:"openapi"`
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas         map[string]json.RawMessage `json:"schemas"`
+			SecuritySchemes map[string]struct {
+				Type   string `json:"type"`
+				Scheme string `json:"scheme"`
+			} `json:"securitySchemes"`
+		} `json:"components"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &doc))
+
+	assert.True(t, strings.HasPrefix(doc.OpenAPI, "3."))
+	for path, methods := range map[string][]string{
+		"/user":         {"post"},
+		"/user/{id}":    {"get", "put", "patch", "delete"},
+		"/users":        {"get"},
+		"/users/batch":  {"post"},
+		"/users/events": {"get"},
+	} {
+		for _, method := range methods {
+			assert.Contains(t, doc.Paths[path], method, path)
+		}
+	}
+	assert.Contains(t, doc.Components.Schemas, "Status")
+	assert.Equal(t, "http", doc.Components.SecuritySchemes["bearer"].Type)
+	assert.Equal(t, "bearer", doc.Components.SecuritySchemes["bearer"].Scheme)
+}
+
+func TestDocs(t *testing.T) {
+	w := httptest.NewRecorder()
+	Docs(w, httptest.NewRequest(http.MethodGet, "/docs", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `fetch("/openapi.json")`)
+	assert.NotContains(t, w.Body.String(), "<script src=")
+	assert.NotContains(t, w.Body.String(), "<link")
+}
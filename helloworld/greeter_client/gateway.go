@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	pb "google.golang.org/grpc/examples/helloworld/helloworld"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// newGatewayMux serves the REST routes generated from the google.api.http
+// annotations in helloworld.proto by forwarding them over conn.
+func newGatewayMux(ctx context.Context, conn *grpc.ClientConn) (*runtime.ServeMux, error) {
+	mux := runtime.NewServeMux(
+		runtime.WithMarshalerOption(runtime.MIMEWildcard, &runtime.HTTPBodyMarshaler{
+			Marshaler: &runtime.JSONPb{
+				MarshalOptions:   protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true},
+				UnmarshalOptions: protojson.UnmarshalOptions{DiscardUnknown: true},
+			},
+		}),
+		runtime.WithIncomingHeaderMatcher(headerMatcher),
+		runtime.WithForwardResponseOption(setETag),
+		runtime.WithErrorHandler(errorHandler),
+		runtime.WithMiddlewares(recordRoute, rejectQueryPasswords),
+	)
+
+	if err := pb.RegisterUserServiceHandler(ctx, mux, conn); err != nil {
+		return nil, err
+	}
+
+	return mux, nil
+}
+
+// headerMatcher forwards the headers the user service reads as metadata, in
+// addition to Authorization which the gateway always forwards.
+func headerMatcher(key string) (string, bool) {
+	switch http.CanonicalHeaderKey(key) {
+	case "Idempotency-Key":
+		return "idempotency-key", true
+	case "If-Match":
+		return "if-match", true
+	}
+
+	return runtime.DefaultHeaderMatcher(key)
+}
+
+// rejectQueryPasswords refuses passwords in query strings, which end up in
+// access logs and browser history.
+func rejectQueryPasswords(next runtime.HandlerFunc) runtime.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+		if r.URL.Query().Has("password") {
+			http.Error(w, "Password must be sent in the request body", http.StatusBadRequest)
+			return
+		}
+
+		next(w, r, pathParams)
+	}
+}
+
+func etag(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+// setETag returns the version of the user in a response as its ETag, for
+// clients to send back in If-Match.
+func setETag(ctx context.Context, w http.ResponseWriter, resp proto.Message) error {
+	withUser, ok := resp.(interface{ GetUser() *pb.User })
+	if !ok {
+		return nil
+	}
+
+	if version := withUser.GetUser().GetVersion(); version > 0 {
+		w.Header().Set("ETag", etag(version))
+	}
+
+	return nil
+}
+
+type statusOverride struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusOverride) WriteHeader(int) {
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+// versionConflictReason is the ErrorInfo reason the user service attaches to
+// Aborted errors caused by a stale version.
+const versionConflictReason = "VERSION_CONFLICT"
+
+func isVersionConflict(err error) bool {
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok && info.Reason == versionConflictReason {
+			return true
+		}
+	}
+
+	return false
+}
+
+// errorHandler maps version conflicts to the HTTP precondition statuses and
+// leaves every other code to the gateway defaults.
+func errorHandler(ctx context.Context, mux *runtime.ServeMux, marshaler runtime.Marshaler, w http.ResponseWriter, r *http.Request, err error) {
+	switch status.Code(err) {
+	case codes.Aborted:
+		if isVersionConflict(err) {
+			w = &statusOverride{ResponseWriter: w, status: http.StatusPreconditionFailed}
+		}
+	case codes.FailedPrecondition:
+		if method, _ := runtime.RPCMethod(ctx); method == "/helloworld.UserService/CreateUser" {
+			// An Idempotency-Key reused with a different body.
+			w = &statusOverride{ResponseWriter: w, status: http.StatusUnprocessableEntity}
+		} else {
+			w = &statusOverride{ResponseWriter: w, status: http.StatusPreconditionRequired}
+		}
+	}
+
+	runtime.DefaultHTTPErrorHandler(ctx, mux, marshaler, w, r, err)
+}
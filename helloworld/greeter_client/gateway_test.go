@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/api/httpbody"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	pb "google.golang.org/grpc/examples/helloworld/helloworld"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// fakeUserService records what the gateway sends and answers with canned
+// responses.
+type fakeUserService struct {
+	pb.UnimplementedUserServiceServer
+
+	md     metadata.MD
+	create *pb.CreateUserRequest
+	patch  *pb.PatchUserRequest
+	rotate *pb.RotateTokenRequest
+	revoke *pb.RevokeTokenRequest
+	err    error
+}
+
+func (s *fakeUserService) CreateUser(ctx context.Context, req *pb.CreateUserRequest) (*pb.CreateUserResponse, error) {
+	s.md, _ = metadata.FromIncomingContext(ctx)
+	s.create = req
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	user := req.User
+	user.Id, user.Version = 7, 1
+	return &pb.CreateUserResponse{User: user, Token: "secret", Message: "User successfully created"}, nil
+}
+
+func (s *fakeUserService) GetUser(ctx context.Context, req *pb.GetUserRequest) (*pb.GetUserResponse, error) {
+	s.md, _ = metadata.FromIncomingContext(ctx)
+	return &pb.GetUserResponse{User: &pb.User{Id: req.Id, FirstName: "Cool", LastName: "Kid", Age: 10, Version: 3}}, nil
+}
+
+func (s *fakeUserService) UpdateUser(ctx context.Context, req *pb.UpdateUserRequest) (*pb.UpdateUserResponse, error) {
+	s.md, _ = metadata.FromIncomingContext(ctx)
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	st, _ := status.New(codes.Aborted, "User has been modified").WithDetails(&errdetails.ErrorInfo{Reason: versionConflictReason})
+	return nil, st.Err()
+}
+
+func (s *fakeUserService) PatchUser(ctx context.Context, req *pb.PatchUserRequest) (*pb.PatchUserResponse, error) {
+	s.patch = req
+	user := req.User
+	user.Id, user.Version = req.Id, 4
+	return &pb.PatchUserResponse{User: user, Message: "User successfully updated"}, nil
+}
+
+func (s *fakeUserService) RotateToken(ctx context.Context, req *pb.RotateTokenRequest) (*pb.RotateTokenResponse, error) {
+	s.rotate = req
+	return &pb.RotateTokenResponse{Token: "rotated", Message: "Token rotated successfully"}, nil
+}
+
+func (s *fakeUserService) RevokeToken(ctx context.Context, req *pb.RevokeTokenRequest) (*pb.RevokeTokenResponse, error) {
+	s.revoke = req
+	return &pb.RevokeTokenResponse{Revoked: 1, Message: "Token revoked successfully"}, nil
+}
+
+func (s *fakeUserService) WatchUsers(req *pb.WatchUsersRequest, stream pb.UserService_WatchUsersServer) error {
+	for sequence := int64(1); sequence <= 2; sequence++ {
+		if err := stream.Send(&pb.UserEvent{Sequence: sequence, Type: pb.UserEvent_UPDATED, User: &pb.User{Id: 7}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *fakeUserService) Login(ctx context.Context, req *pb.LoginRequest) (*pb.LoginResponse, error) {
+	if req.GetUsername() != "coolkid" || req.Password != "correct horse" {
+		return nil, status.Errorf(codes.Unauthenticated, "Invalid credentials")
+	}
+	return &pb.LoginResponse{Id: 7, Token: "secret", Message: "Logged in successfully"}, nil
+}
+
+func (s *fakeUserService) GetJWKS(ctx context.Context, req *pb.GetJWKSRequest) (*httpbody.HttpBody, error) {
+	return &httpbody.HttpBody{ContentType: "application/json", Data: []byte(`{"keys":[{"kid":"current","kty":"OKP"}]}`)}, nil
+}
+
+func newTestGateway(t *testing.T, service *fakeUserService, opts ...grpc.DialOption) http.Handler {
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	pb.RegisterUserServiceServer(server, service)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	opts = append(opts,
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+
+	conn, err := grpc.Dial("bufnet", opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	gateway, err := newGatewayMux(context.Background(), conn)
+	require.NoError(t, err)
+
+	return gateway
+}
+
+func serve(handler http.Handler, method, path, body string, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestGateway_CreateUser_ForwardsIdempotencyKey(t *testing.T) {
+	service := &fakeUserService{}
+	gateway := newTestGateway(t, service)
+
+	rec := serve(gateway, "POST", "/user", `{"first_name":"Cool","last_name":"Kid","age":10,"unknown":true}`, "Idempotency-Key", "key-1")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var body struct {
+		User struct {
+			ID        string `json:"id"`
+			FirstName string `json:"first_name"`
+		} `json:"user"`
+		Token string `json:"token"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, "7", body.User.ID)
+	assert.Equal(t, "Cool", body.User.FirstName)
+	assert.Equal(t, "secret", body.Token)
+	assert.Equal(t, []string{"key-1"}, service.md.Get("idempotency-key"))
+
+	service.err = status.Errorf(codes.FailedPrecondition, "Idempotency key was used with a different request")
+	rec = serve(gateway, "POST", "/user", `{"first_name":"Cool","last_name":"Kid","age":11}`, "Idempotency-Key", "key-1")
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+}
+
+func TestGateway_CreateUser_PasswordOnlyInBody(t *testing.T) {
+	service := &fakeUserService{}
+	gateway := newTestGateway(t, service)
+
+	rec := serve(gateway, "POST", "/users", `{"user":{"first_name":"Cool","last_name":"Kid","age":10,"username":"coolkid"},"password":"correct horse"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, "correct horse", service.create.Password)
+	assert.Equal(t, "coolkid", service.create.User.Username)
+
+	service.create = nil
+	rec = serve(gateway, "POST", "/user?password=correct+horse", `{"first_name":"Cool","last_name":"Kid","age":10}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Nil(t, service.create, "Expected the request not to reach the service")
+}
+
+func TestGateway_Login(t *testing.T) {
+	gateway := newTestGateway(t, &fakeUserService{})
+
+	rec := serve(gateway, "POST", "/login", `{"username":"coolkid","password":"correct horse"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var body struct {
+		ID    string `json:"id"`
+		Token string `json:"token"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, "7", body.ID)
+	assert.Equal(t, "secret", body.Token)
+
+	rec = serve(gateway, "POST", "/login", `{"username":"coolkid","password":"wrong"}`)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestGateway_GetUser_ReturnsUserWithETag(t *testing.T) {
+	service := &fakeUserService{}
+	gateway := newTestGateway(t, service)
+
+	rec := serve(gateway, "GET", "/user/42", "", "Authorization", "Bearer secret")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	assert.Equal(t, `"3"`, rec.Header().Get("ETag"))
+	assert.JSONEq(t, `{"id":"42","first_name":"Cool","last_name":"Kid","age":10,"version":"3","created_at":null,"updated_at":null,"username":""}`, rec.Body.String())
+	assert.Equal(t, []string{"Bearer secret"}, service.md.Get("authorization"))
+}
+
+func TestGateway_JWKS_IsServedVerbatim(t *testing.T) {
+	gateway := newTestGateway(t, &fakeUserService{})
+
+	rec := serve(gateway, "GET", "/.well-known/jwks.json", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.Equal(t, `{"keys":[{"kid":"current","kty":"OKP"}]}`, rec.Body.String())
+}
+
+func TestGateway_UpdateUser_ForwardsIfMatchAndMapsConflict(t *testing.T) {
+	service := &fakeUserService{}
+	gateway := newTestGateway(t, service)
+
+	rec := serve(gateway, "PUT", "/user/42", `{"first_name":"Cool","last_name":"Kid","age":11}`, "If-Match", `"2"`)
+
+	assert.Equal(t, http.StatusPreconditionFailed, rec.Code)
+	assert.Equal(t, []string{`"2"`}, service.md.Get("if-match"))
+
+	service.err = status.Errorf(codes.Aborted, "Watcher fell behind, resume from the last received sequence")
+	rec = serve(gateway, "PUT", "/user/42", `{"first_name":"Cool","last_name":"Kid","age":11}`)
+	assert.Equal(t, http.StatusConflict, rec.Code, "Expected other aborts to keep the default status")
+}
+
+func TestGateway_PatchUser_DerivesMaskFromBody(t *testing.T) {
+	service := &fakeUserService{}
+	gateway := newTestGateway(t, service)
+
+	rec := serve(gateway, "PATCH", "/user/42", `{"age":12}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	assert.Equal(t, int64(42), service.patch.Id)
+	assert.Equal(t, []string{"age"}, service.patch.UpdateMask.GetPaths())
+	assert.Equal(t, `"4"`, rec.Header().Get("ETag"))
+}
+
+func TestGateway_TokenRoutes(t *testing.T) {
+	service := &fakeUserService{}
+	gateway := newTestGateway(t, service)
+
+	rec := serve(gateway, "POST", "/user/42/token:revoke", `{"token_to_revoke":"old"}`, "Authorization", "Bearer secret")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	assert.Nil(t, service.rotate, "Expected :revoke not to be routed to RotateToken")
+	assert.Equal(t, int64(42), service.revoke.Id)
+	assert.Equal(t, "old", service.revoke.TokenToRevoke)
+	assert.JSONEq(t, `{"revoked":1,"message":"Token revoked successfully"}`, rec.Body.String())
+
+	rec = serve(gateway, "POST", "/user/42/token", `{"grace_period":"60s"}`, "Authorization", "Bearer secret")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	assert.Equal(t, int64(42), service.rotate.Id)
+	assert.Equal(t, time.Minute, service.rotate.GracePeriod.AsDuration())
+	assert.JSONEq(t, `{"token":"rotated","expires_at":null,"message":"Token rotated successfully"}`, rec.Body.String())
+}
+
+func TestGateway_UnimplementedRoute(t *testing.T) {
+	gateway := newTestGateway(t, &fakeUserService{})
+
+	rec := serve(gateway, "DELETE", "/user/42", "")
+	assert.Equal(t, http.StatusNotImplemented, rec.Code)
+
+	rec = serve(gateway, "GET", "/nowhere", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/examples/helloworld/internal/logging"
+)
+
+func TestRequestID_IsEchoedAndForwarded(t *testing.T) {
+	service := &fakeUserService{}
+	gateway := requestIDMiddleware(newTestGateway(t, service,
+		grpc.WithChainUnaryInterceptor(requestIDUnaryInterceptor),
+		grpc.WithChainStreamInterceptor(requestIDStreamInterceptor)))
+
+	t.Run("incoming", func(t *testing.T) {
+		rec := serve(gateway, "GET", "/user/42", "", logging.Header, "req-123")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		assert.Equal(t, "req-123", rec.Header().Get(logging.Header))
+		assert.Equal(t, []string{"req-123"}, service.md.Get(logging.MetadataKey))
+	})
+
+	t.Run("generated", func(t *testing.T) {
+		rec := serve(gateway, "GET", "/user/42", "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		id := rec.Header().Get(logging.Header)
+		assert.NotEmpty(t, id)
+		assert.Equal(t, []string{id}, service.md.Get(logging.MetadataKey))
+	})
+}
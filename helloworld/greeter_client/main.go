@@ -3,211 +3,279 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
-	"strconv"
-	"strings"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 	pb "google.golang.org/grpc/examples/helloworld/helloworld"
+	"google.golang.org/grpc/examples/helloworld/internal/config"
+	"google.golang.org/grpc/examples/helloworld/internal/logging"
+	"google.golang.org/grpc/examples/helloworld/internal/tlsconfig"
+	"google.golang.org/grpc/examples/helloworld/internal/tracing"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/status"
 )
 
 var (
-	grpcServerAddr = "localhost:50051"
-	httpServerAddr = ":8080"
+	grpcServerAddr   = flag.String("grpc-addr", "localhost:50051", "address of the gRPC user service")
+	httpServerAddr   = flag.String("http-addr", ":8080", "address the HTTP gateway listens on")
+	printConfig      = flag.Bool("print-config", false, "print the effective configuration and exit")
+	grpcTLS          = flag.Bool("grpc-tls", false, "connect to the user service over TLS; implied by the other grpc-tls-* settings")
+	grpcTLSCA        = flag.String("grpc-tls-ca", "", "PEM CA bundle that signed the user service certificate; defaults to the system roots")
+	grpcTLSCert      = flag.String("grpc-tls-cert", "", "PEM client certificate presented to the user service (mTLS)")
+	grpcTLSKey       = flag.String("grpc-tls-key", "", "PEM private key for grpc-tls-cert")
+	grpcServerName   = flag.String("grpc-tls-server-name", "", "name expected in the user service certificate; defaults to the grpc-addr host")
+	tlsReload        = flag.Duration("tls-reload-interval", 30*time.Second, "how often TLS files are checked for changes")
+	traceExporter    = flag.String("trace-exporter", "none", "where spans are sent: none, otlp, stdout or file")
+	traceEndpoint    = flag.String("trace-otlp-endpoint", "", "OTLP gRPC collector address; defaults to OTEL_EXPORTER_OTLP_ENDPOINT")
+	traceInsecure    = flag.Bool("trace-otlp-insecure", false, "send spans to the collector without TLS")
+	traceFile        = flag.String("trace-file", "traces.json", "file the file exporter appends JSON spans to")
+	traceSampleRatio = flag.Float64("trace-sample-ratio", 1, "fraction of new traces that are recorded")
+	logFormat        = flag.String("log-format", "text", "log output format: text or json")
+	logLevel         = flag.String("log-level", "info", "minimum log level: debug, info, warn or error")
+	readyTimeout     = flag.Duration("ready-timeout", 2*time.Second, "how long /readyz waits for the user service health check")
+	shutdownTimeout  = flag.Duration("shutdown-timeout", 30*time.Second, "how long in-flight HTTP requests may run after SIGINT or SIGTERM")
 )
 
-type UserDetails struct {
-	Id         int64  `json: "id"`
-	First_name string `json: "first_name"`
-	Last_name  string `json: "last_name"`
-	Age        int32  `json: "age"`
-}
+func validateConfig() error {
+	var errs []error
 
-func Create(client pb.UserServiceClient, w http.ResponseWriter, r *http.Request) {
+	if _, _, err := net.SplitHostPort(*grpcServerAddr); err != nil {
+		errs = append(errs, fmt.Errorf("grpc-addr: %w", err))
+	}
 
-	var usr UserDetails
+	if _, _, err := net.SplitHostPort(*httpServerAddr); err != nil {
+		errs = append(errs, fmt.Errorf("http-addr: %w", err))
+	}
 
-	err := json.NewDecoder(r.Body).Decode(&usr)
+	tlsFiles := tlsconfig.Files{Cert: *grpcTLSCert, Key: *grpcTLSKey, CA: *grpcTLSCA}
+	if err := tlsFiles.Validate(); err != nil {
+		errs = append(errs, err)
+	}
 
-	if err != nil {
-		http.Error(w, "Error decoding JSON", http.StatusBadRequest)
-		return
+	if *tlsReload <= 0 {
+		errs = append(errs, errors.New("tls-reload-interval: must be positive"))
 	}
 
-	user := &pb.User{
-		Id:        usr.Id,
-		FirstName: usr.First_name,
-		LastName:  usr.Last_name,
-		Age:       usr.Age,
+	if err := traceConfig().Validate(); err != nil {
+		errs = append(errs, err)
 	}
 
-	res, err := client.CreateUser(context.Background(), &pb.CreateUserRequest{
-		User: user,
-	})
+	if *readyTimeout <= 0 {
+		errs = append(errs, errors.New("ready-timeout: must be positive"))
+	}
 
-	if err != nil {
-		log.Fatal("error while creating user %v", err)
+	if *shutdownTimeout <= 0 {
+		errs = append(errs, errors.New("shutdown-timeout: must be positive"))
 	}
 
-	json.NewEncoder(w).Encode(struct {
-		User    *pb.User `json:"user,omitempty"`
-		Token   string   `json:"token"`
-		Message string   ` json:"message"`
-	}{
-		User:    res.User,
-		Token:   res.Token,
-		Message: res.Message,
-	})
+	return errors.Join(errs...)
 }
 
-func GetUser(client pb.UserServiceClient, w http.ResponseWriter, r *http.Request) {
-	param := mux.Vars(r)
-
-	userId, err := strconv.Atoi(param["id"])
-	if err != nil {
-		panic(err)
+func traceConfig() tracing.Config {
+	return tracing.Config{
+		Exporter:    *traceExporter,
+		Endpoint:    *traceEndpoint,
+		Insecure:    *traceInsecure,
+		File:        *traceFile,
+		SampleRatio: *traceSampleRatio,
 	}
+}
+
+// draining is set once the gateway starts shutting down so /readyz takes it
+// out of rotation while in-flight requests finish.
+var draining atomic.Bool
+
+// Healthz reports that the gateway process is alive.
+func Healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Status string `json:"status"`
+	}{"ok"})
+}
 
-	bearerToken := extractBearerToken(r)
+// Readyz reports whether the gateway can serve traffic: it is not draining,
+// its gRPC connection is usable and the user service reports SERVING.
+func Readyz(conn *grpc.ClientConn, health healthpb.HealthClient, w http.ResponseWriter, r *http.Request) {
+	if draining.Load() {
+		http.Error(w, "Shutting down", http.StatusServiceUnavailable)
+		return
+	}
 
-	if bearerToken == "" {
-		http.Error(w, "Unauthorized: Bearer token not provided", http.StatusUnauthorized)
+	switch state := conn.GetState(); state {
+	case connectivity.TransientFailure, connectivity.Shutdown:
+		http.Error(w, "gRPC connection is "+state.String(), http.StatusServiceUnavailable)
 		return
 	}
 
-	res, err := client.GetUser(context.Background(), &pb.GetUserRequest{
-		Id:    int64(userId),
-		Token: bearerToken,
-	})
+	ctx, cancel := context.WithTimeout(r.Context(), *readyTimeout)
+	defer cancel()
 
+	res, err := health.Check(ctx, &healthpb.HealthCheckRequest{Service: pb.UserService_ServiceDesc.ServiceName})
 	if err != nil {
-		statusErr, ok := status.FromError(err)
-
-		if ok {
-			switch statusErr.Code() {
-			case codes.Unauthenticated:
-				http.Error(w, "Unauthorized: Invalid Bearer token", http.StatusUnauthorized)
-				return
-			default:
-				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-				return
-			}
-		}
-
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		http.Error(w, "User service health check failed: "+status.Convert(err).Message(), http.StatusServiceUnavailable)
 		return
 	}
 
-	usr := UserDetails{
-		Id:         res.User.Id,
-		First_name: res.User.FirstName,
-		Last_name:  res.User.LastName,
-		Age:        res.User.Age,
+	if res.Status != healthpb.HealthCheckResponse_SERVING {
+		http.Error(w, "User service is "+res.Status.String(), http.StatusServiceUnavailable)
+		return
 	}
 
-	json.NewEncoder(w).Encode(usr)
-
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Status string `json:"status"`
+	}{"ready"})
 }
 
-func UpdateUser(client pb.UserServiceClient, w http.ResponseWriter, r *http.Request) {
-	var usr UserDetails
+// newRouter serves the gateway and the operational endpoints behind the
+// tracing, request ID and metrics middlewares.
+func newRouter(conn *grpc.ClientConn, gateway http.Handler, registry *prometheus.Registry, spec []byte) *mux.Router {
+	router := mux.NewRouter()
+	router.Use(otelmux.Middleware("greeter_client"), requestIDMiddleware, newHTTPMetrics(registry).middleware)
+
+	router.HandleFunc("/healthz", Healthz).Methods("GET")
+
+	router.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{})).Methods("GET")
+
+	healthClient := healthpb.NewHealthClient(conn)
+	router.HandleFunc("/readyz", func(writer http.ResponseWriter, req *http.Request) {
+		Readyz(conn, healthClient, writer, req)
+	}).Methods("GET")
+
+	router.HandleFunc("/openapi.json", OpenAPI(spec)).Methods("GET")
+
+	router.HandleFunc("/docs", Docs).Methods("GET")
 
-	param := mux.Vars(r)
+	// Every UserService route comes from the generated gateway.
+	router.PathPrefix("/").Handler(gateway)
 
-	userId, err := strconv.Atoi(param["id"])
+	return router
+}
+
+func main() {
+	if err := config.Load(flag.CommandLine, os.Args[1:], "GREETER_CLIENT"); err != nil {
+		logging.Fatal("Invalid configuration", "err", err)
+	}
 
+	logger, err := logging.New(os.Stderr, *logFormat, *logLevel)
 	if err != nil {
-		panic(err)
+		logging.Fatal("Invalid configuration", "err", err)
 	}
+	slog.SetDefault(logger)
 
-	bearerToken := extractBearerToken(r)
+	if err := validateConfig(); err != nil {
+		logging.Fatal("Invalid configuration", "err", err)
+	}
 
-	if bearerToken == "" {
-		http.Error(w, "Unauthorized: Bearer token not provided", http.StatusUnauthorized)
+	if *printConfig {
+		if err := config.Print(os.Stdout, flag.CommandLine); err != nil {
+			logging.Fatal("Failed to print configuration", "err", err)
+		}
 		return
 	}
 
-	json.NewDecoder(r.Body).Decode(&usr)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	user := &pb.User{
-		Id:        usr.Id,
-		FirstName: usr.First_name,
-		LastName:  usr.Last_name,
-		Age:       usr.Age,
+	creds := insecure.NewCredentials()
+
+	if *grpcTLS || *grpcTLSCA != "" || *grpcTLSCert != "" || *grpcServerName != "" {
+		reloader, err := tlsconfig.NewReloader(tlsconfig.Files{Cert: *grpcTLSCert, Key: *grpcTLSKey, CA: *grpcTLSCA})
+		if err != nil {
+			logging.Fatal("Failed to load TLS certificates", "err", err)
+		}
+
+		go reloader.Watch(ctx, *tlsReload)
+		creds = credentials.NewTLS(reloader.ClientConfig(*grpcServerName))
 	}
 
-	res, err := client.UpdateUser(context.Background(), &pb.UpdateUserRequest{
-		User:  user,
-		Id:    int64(userId),
-		Token: bearerToken,
-	})
+	shutdownTracing, err := tracing.Setup(ctx, "greeter_client", traceConfig())
+	if err != nil {
+		logging.Fatal("Failed to set up tracing", "err", err)
+	}
 
+	conn, err := grpc.Dial(*grpcServerAddr,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+		grpc.WithChainUnaryInterceptor(requestIDUnaryInterceptor),
+		grpc.WithChainStreamInterceptor(requestIDStreamInterceptor),
+	)
 	if err != nil {
-		statusErr, ok := status.FromError(err)
-
-		if ok {
-			switch statusErr.Code() {
-			case codes.Unauthenticated:
-				http.Error(w, "Unauthorized: Invalid Bearer token", http.StatusUnauthorized)
-				return
-			default:
-				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-				return
-			}
-		}
+		logging.Fatal("did not connect", "addr", *grpcServerAddr, "err", err)
+	}
 
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
+	gateway, err := newGatewayMux(ctx, conn)
+	if err != nil {
+		logging.Fatal("Failed to register gateway routes", "err", err)
 	}
 
-	json.NewEncoder(w).Encode(struct {
-		User    *pb.User `json:"user,omitempty"`
-		Message string   ` json:"message"`
-	}{
-		User:    res.User,
-		Message: res.Message,
-	})
-}
+	spec, err := openAPIJSON()
+	if err != nil {
+		logging.Fatal("Failed to load the OpenAPI document", "err", err)
+	}
 
-func extractBearerToken(r *http.Request) string {
-	authHeader := r.Header.Get("Authorization")
-	if authHeader != "" {
-		authParts := strings.Split(authHeader, " ")
-		if len(authParts) == 2 && strings.ToLower(authParts[0]) == "bearer" {
-			return authParts[1]
-		}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+
+	httpServer := &http.Server{
+		Addr:    *httpServerAddr,
+		Handler: newRouter(conn, gateway, registry, spec),
 	}
-	return ""
-}
 
-func main() {
-	conn, err := grpc.Dial(grpcServerAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
-	if err != nil {
-		log.Fatalf("did not connect: %v", err)
+	served := make(chan error, 1)
+	go func() {
+		slog.Info("HTTP Server listening", "addr", *httpServerAddr)
+		served <- httpServer.ListenAndServe()
+	}()
+
+	select {
+	case err := <-served:
+		logging.Fatal("Failed to serve HTTP", "err", err)
+	case <-ctx.Done():
+		stop()
 	}
-	defer conn.Close()
 
-	client := pb.NewUserServiceClient(conn)
+	slog.Info("shutting down, draining in-flight requests")
+	draining.Store(true)
 
-	router := mux.NewRouter()
+	// Stop accepting requests and let the running ones finish before the
+	// gRPC connection they use goes away.
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
 
-	router.HandleFunc("/user", func(writer http.ResponseWriter, req *http.Request) {
-		Create(client, writer, req)
-	}).Methods("POST")
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("in-flight requests did not finish in time", "timeout", *shutdownTimeout, "err", err)
+	}
 
-	router.HandleFunc("/user/{id}", func(writer http.ResponseWriter, req *http.Request) {
-		GetUser(client, writer, req)
-	}).Methods("GET")
+	if err := conn.Close(); err != nil {
+		slog.Error("Error closing gRPC connection", "err", err)
+	}
+
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFlush()
 
-	router.HandleFunc("/user/{id}", func(writer http.ResponseWriter, req *http.Request) {
-		UpdateUser(client, writer, req)
-	}).Methods("PUT")
+	if err := shutdownTracing(flushCtx); err != nil {
+		slog.Error("Error flushing traces", "err", err)
+	}
 
-	log.Println("HTTP Server listening on", httpServerAddr)
-	http.ListenAndServe(httpServerAddr, router)
+	slog.Info("gateway stopped")
 }
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	pb "google.golang.org/grpc/examples/helloworld/helloworld"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func newHealthTestConn(t *testing.T) (*grpc.ClientConn, *health.Server) {
+	listener := bufconn.Listen(1 << 20)
+	healthServer := health.NewServer()
+
+	server := grpc.NewServer()
+	healthpb.RegisterHealthServer(server, healthServer)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return conn, healthServer
+}
+
+func readyz(conn *grpc.ClientConn) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	Readyz(conn, healthpb.NewHealthClient(conn), rec, httptest.NewRequest("GET", "/readyz", nil))
+	return rec
+}
+
+func TestHealthz_ReturnsOK(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Healthz(rec, httptest.NewRequest("GET", "/healthz", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"status":"ok"}`, rec.Body.String())
+}
+
+func TestReadyz(t *testing.T) {
+	service := pb.UserService_ServiceDesc.ServiceName
+
+	t.Run("serving", func(t *testing.T) {
+		conn, healthServer := newHealthTestConn(t)
+		healthServer.SetServingStatus(service, healthpb.HealthCheckResponse_SERVING)
+
+		rec := readyz(conn)
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.JSONEq(t, `{"status":"ready"}`, rec.Body.String())
+	})
+
+	t.Run("not serving", func(t *testing.T) {
+		conn, healthServer := newHealthTestConn(t)
+		healthServer.SetServingStatus(service, healthpb.HealthCheckResponse_NOT_SERVING)
+
+		rec := readyz(conn)
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.Contains(t, rec.Body.String(), "User service is NOT_SERVING")
+	})
+
+	t.Run("connection closed", func(t *testing.T) {
+		conn, healthServer := newHealthTestConn(t)
+		healthServer.SetServingStatus(service, healthpb.HealthCheckResponse_SERVING)
+		require.NoError(t, conn.Close())
+
+		rec := readyz(conn)
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.Contains(t, rec.Body.String(), "gRPC connection is SHUTDOWN")
+	})
+
+	t.Run("draining", func(t *testing.T) {
+		conn, healthServer := newHealthTestConn(t)
+		healthServer.SetServingStatus(service, healthpb.HealthCheckResponse_SERVING)
+
+		draining.Store(true)
+		t.Cleanup(func() { draining.Store(false) })
+
+		rec := readyz(conn)
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.Contains(t, rec.Body.String(), "Shutting down")
+	})
+}
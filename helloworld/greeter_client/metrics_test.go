@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPMetrics_LabelsByRouteTemplate(t *testing.T) {
+	registry := prometheus.NewRegistry()
+
+	router := mux.NewRouter()
+	router.Use(newHTTPMetrics(registry).middleware)
+	router.HandleFunc("/healthz", Healthz).Methods("GET")
+	router.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{})).Methods("GET")
+	router.PathPrefix("/").Handler(newTestGateway(t, &fakeUserService{}))
+
+	require.Equal(t, http.StatusOK, serve(router, "GET", "/user/5", "").Code)
+	require.Equal(t, http.StatusOK, serve(router, "GET", "/user/6", "").Code)
+	require.Equal(t, http.StatusOK, serve(router, "GET", "/healthz", "").Code)
+
+	rec := serve(router, "GET", "/metrics", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	metrics := rec.Body.String()
+	assert.Contains(t, metrics, `http_requests_total{code="200",method="GET",route="/user/{id}"} 2`)
+	assert.Contains(t, metrics, `http_requests_total{code="200",method="GET",route="/healthz"} 1`)
+	assert.NotContains(t, metrics, `route="/user/5"`)
+	assert.NotContains(t, metrics, `route="/"`, "Expected gateway routes not to be labelled with the catch-all prefix")
+}
+
+func TestRouter_StreamsThroughMiddlewares(t *testing.T) {
+	// The gRPC connection is only used by /readyz, which is not called.
+	router := newRouter(nil, newTestGateway(t, &fakeUserService{}), prometheus.NewRegistry(), nil)
+
+	rec := serve(router, "GET", "/users/events", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	require.Len(t, lines, 2, rec.Body.String())
+	assert.Contains(t, lines[0], `"sequence":"1"`)
+	assert.Contains(t, lines[1], `"sequence":"2"`)
+	assert.True(t, rec.Flushed, "Expected every event to be flushed to the client")
+}
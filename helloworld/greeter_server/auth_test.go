@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	pb "google.golang.org/grpc/examples/helloworld/helloworld"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func newAuthTestClient(t *testing.T, server *userServiceServer) pb.UserServiceClient {
+	listener := bufconn.Listen(1 << 20)
+
+	grpcServer := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(server.authUnaryInterceptor),
+		grpc.ChainStreamInterceptor(server.authStreamInterceptor),
+	)
+	pb.RegisterUserServiceServer(grpcServer, server)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return pb.NewUserServiceClient(conn)
+}
+
+// authenticated is the context of a call the interceptor resolved to id.
+func authenticated(id int64) context.Context {
+	return withPrincipal(context.Background(), principal{UserID: id})
+}
+
+func authenticatedAdmin(id int64) context.Context {
+	return withPrincipal(context.Background(), principal{UserID: id, Admin: true})
+}
+
+func withBearer(token string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+}
+
+// callAuthenticated runs handler behind the auth interceptor, so the token in
+// req is checked the way a served call checks it.
+func callAuthenticated[Req, Resp any](server *userServiceServer, method string, req Req, handler func(context.Context, Req) (Resp, error)) (Resp, error) {
+	info := &grpc.UnaryServerInfo{FullMethod: "/helloworld.UserService/" + method}
+	resp, err := server.authUnaryInterceptor(context.Background(), req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return handler(ctx, req.(Req))
+	})
+
+	typed, _ := resp.(Resp)
+	return typed, err
+}
+
+func TestAuthInterceptor_BearerMetadata_AuthenticatesCaller(t *testing.T) {
+	server := &userServiceServer{Store: newMemoryStore(), events: newUserEvents(10)}
+	client := newAuthTestClient(t, server)
+
+	alice, err := client.CreateUser(context.Background(), &pb.CreateUserRequest{User: &pb.User{FirstName: "Alice", LastName: "Kid", Age: 10}})
+	require.NoError(t, err)
+	bob, err := client.CreateUser(context.Background(), &pb.CreateUserRequest{User: &pb.User{FirstName: "Bob", LastName: "Kid", Age: 11}})
+	require.NoError(t, err)
+
+	resp, err := client.GetUser(withBearer(alice.Token), &pb.GetUserRequest{Id: alice.User.Id})
+	require.NoError(t, err)
+	assert.Equal(t, "Alice", resp.User.FirstName)
+
+	_, err = client.GetUser(withBearer(alice.Token), &pb.GetUserRequest{Id: bob.User.Id})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = client.DeleteUser(withBearer("forged"), &pb.DeleteUserRequest{Id: alice.User.Id})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = client.ListUsers(withBearer("forged"), &pb.ListUsersRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), "Expected a bad token to be rejected on every route that needs a caller")
+
+	_, err = client.ListUsers(context.Background(), &pb.ListUsersRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), "Expected anonymous callers not to list users")
+}
+
+func TestListUsers_OnlyAdminsSeeEveryUser(t *testing.T) {
+	server := &userServiceServer{Store: newMemoryStore(), events: newUserEvents(10)}
+	client := newAuthTestClient(t, server)
+
+	alice, err := client.CreateUser(context.Background(), &pb.CreateUserRequest{User: &pb.User{FirstName: "Alice", LastName: "Kid", Age: 10}})
+	require.NoError(t, err)
+	bob, err := client.CreateUser(context.Background(), &pb.CreateUserRequest{User: &pb.User{FirstName: "Bob", LastName: "Kid", Age: 11}})
+	require.NoError(t, err)
+
+	server.admins = map[int64]bool{alice.User.Id: true}
+
+	resp, err := client.ListUsers(withBearer(bob.Token), &pb.ListUsersRequest{})
+	require.NoError(t, err)
+	require.Len(t, resp.Users, 1)
+	assert.Equal(t, bob.User.Id, resp.Users[0].Id)
+
+	resp, err = client.ListUsers(withBearer(alice.Token), &pb.ListUsersRequest{})
+	require.NoError(t, err)
+	assert.Len(t, resp.Users, 2)
+}
+
+func TestAuthInterceptor_PublicMethods_IgnoreBadTokens(t *testing.T) {
+	server := &userServiceServer{Store: newMemoryStore(), events: newUserEvents(10), passwords: passwordPolicy{MinLength: 8}}
+	client := newAuthTestClient(t, server)
+
+	created, err := client.CreateUser(withBearer("forged"), &pb.CreateUserRequest{
+		User:     &pb.User{FirstName: "Cool", LastName: "Kid", Age: 10, Username: "coolkid"},
+		Password: "correct horse",
+	})
+	require.NoError(t, err, "Expected a bad token not to block sign-up")
+
+	_, err = client.RevokeToken(withBearer(created.Token), &pb.RevokeTokenRequest{Id: created.User.Id})
+	require.NoError(t, err)
+
+	login, err := client.Login(withBearer(created.Token), &pb.LoginRequest{User: &pb.LoginRequest_Username{Username: "coolkid"}, Password: "correct horse"})
+	require.NoError(t, err, "Expected a revoked token not to block logging in again")
+
+	_, err = client.GetUser(withBearer(created.Token), &pb.GetUserRequest{Id: created.User.Id})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = client.GetUser(withBearer(login.Token), &pb.GetUserRequest{Id: created.User.Id})
+	assert.NoError(t, err)
+}
+
+func TestAuthInterceptor_DeprecatedTokenField_StillWorks(t *testing.T) {
+	server := &userServiceServer{Store: newMemoryStore(), events: newUserEvents(10)}
+	client := newAuthTestClient(t, server)
+
+	created, err := client.CreateUser(context.Background(), &pb.CreateUserRequest{User: &pb.User{FirstName: "Cool", LastName: "Kid", Age: 10}})
+	require.NoError(t, err)
+
+	_, err = client.GetUser(context.Background(), &pb.GetUserRequest{Id: created.User.Id, Token: created.Token})
+	assert.NoError(t, err)
+
+	_, err = client.GetUser(context.Background(), &pb.GetUserRequest{Id: created.User.Id, Token: "wrong"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestAuthenticate_OtherServices_AreNotChecked(t *testing.T) {
+	server := &userServiceServer{Store: newMemoryStore()}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer forged"))
+
+	ctx, err := server.authenticate(ctx, "/grpc.health.v1.Health/Check", nil)
+
+	assert.NoError(t, err)
+	_, ok := principalFromContext(ctx)
+	assert.False(t, ok)
+}
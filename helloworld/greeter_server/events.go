@@ -0,0 +1,131 @@
+package main
+
+import (
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	pb "google.golang.org/grpc/examples/helloworld/helloworld"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+var errShuttingDown = status.Error(codes.Unavailable, "Server is shutting down")
+
+const (
+	eventHistorySize     = 1024
+	subscriberBufferSize = 64
+)
+
+// userEvents fans out user changes to WatchUsers streams and keeps a bounded
+// history so reconnecting watchers can resume from the last sequence they saw.
+type userEvents struct {
+	mu          sync.Mutex
+	sequence    int64
+	history     []*pb.UserEvent
+	size        int
+	subscribers map[chan *pb.UserEvent]struct{}
+	done        chan struct{}
+}
+
+func newUserEvents(size int) *userEvents {
+	return &userEvents{
+		size:        size,
+		subscribers: make(map[chan *pb.UserEvent]struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+func (e *userEvents) publish(eventType pb.UserEvent_Type, user *pb.User) {
+	if e == nil {
+		return
+	}
+
+	user = proto.Clone(user).(*pb.User)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.sequence++
+
+	event := &pb.UserEvent{
+		Sequence: e.sequence,
+		Type:     eventType,
+		User:     user,
+	}
+
+	e.history = append(e.history, event)
+	if len(e.history) > e.size {
+		e.history = e.history[len(e.history)-e.size:]
+	}
+
+	for ch := range e.subscribers {
+		select {
+		case ch <- event:
+		default:
+			// The watcher can't keep up; drop it so it reconnects with its
+			// last sequence instead of silently missing events.
+			delete(e.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe returns the events after since together with a channel of new
+// events. A zero since only delivers new events.
+func (e *userEvents) subscribe(since int64) ([]*pb.UserEvent, chan *pb.UserEvent, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	select {
+	case <-e.done:
+		return nil, nil, errShuttingDown
+	default:
+	}
+
+	var backlog []*pb.UserEvent
+
+	if since > 0 {
+		if since > e.sequence {
+			return nil, nil, status.Errorf(codes.OutOfRange, "Resume position %d is ahead of the feed", since)
+		}
+
+		oldest := e.sequence - int64(len(e.history)) + 1
+		if since < oldest-1 {
+			return nil, nil, status.Errorf(codes.OutOfRange, "Resume position %d is no longer available", since)
+		}
+
+		backlog = append(backlog, e.history[since-oldest+1:]...)
+	}
+
+	ch := make(chan *pb.UserEvent, subscriberBufferSize)
+	e.subscribers[ch] = struct{}{}
+
+	return backlog, ch, nil
+}
+
+func (e *userEvents) unsubscribe(ch chan *pb.UserEvent) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.subscribers[ch]; ok {
+		delete(e.subscribers, ch)
+		close(ch)
+	}
+}
+
+// shutdown ends every WatchUsers stream so a graceful stop does not wait for
+// watchers that would otherwise stay connected forever.
+func (e *userEvents) shutdown() {
+	if e == nil {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	select {
+	case <-e.done:
+	default:
+		close(e.done)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	pb "google.golang.org/grpc/examples/helloworld/helloworld"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+func TestUserEvents_Publish_CopiesUser(t *testing.T) {
+	events := newUserEvents(10)
+
+	_, ch, err := events.subscribe(0)
+	assert.NoError(t, err)
+
+	user := &pb.User{Id: 1, FirstName: "Cool", LastName: "Kid", Age: 10}
+	events.publish(pb.UserEvent_CREATED, user)
+	user.FirstName = "Changed"
+
+	event := <-ch
+	assert.Equal(t, int64(1), event.Sequence)
+	assert.Equal(t, pb.UserEvent_CREATED, event.Type)
+	assert.Equal(t, "Cool", event.User.FirstName, "Expected the event to keep the user as published")
+}
+
+func TestUserEvents_Subscribe_ResumesAfterSequence(t *testing.T) {
+	events := newUserEvents(10)
+
+	for i := int64(1); i <= 5; i++ {
+		events.publish(pb.UserEvent_UPDATED, &pb.User{Id: i})
+	}
+
+	backlog, _, err := events.subscribe(3)
+
+	assert.NoError(t, err)
+	assert.Len(t, backlog, 2)
+	assert.Equal(t, int64(4), backlog[0].Sequence)
+	assert.Equal(t, int64(5), backlog[1].Sequence)
+}
+
+func TestUserEvents_Subscribe_EvictedPosition_ReturnsOutOfRange(t *testing.T) {
+	events := newUserEvents(3)
+
+	for i := int64(1); i <= 6; i++ {
+		events.publish(pb.UserEvent_UPDATED, &pb.User{Id: i})
+	}
+
+	backlog, _, err := events.subscribe(3)
+	assert.NoError(t, err)
+	assert.Len(t, backlog, 3)
+
+	_, _, err = events.subscribe(2)
+	assert.Equal(t, codes.OutOfRange, status.Code(err))
+
+	_, _, err = events.subscribe(7)
+	assert.Equal(t, codes.OutOfRange, status.Code(err))
+}
+
+func TestUserEvents_SlowSubscriber_IsDropped(t *testing.T) {
+	events := newUserEvents(subscriberBufferSize * 2)
+
+	_, ch, err := events.subscribe(0)
+	assert.NoError(t, err)
+
+	for i := 0; i <= subscriberBufferSize; i++ {
+		events.publish(pb.UserEvent_UPDATED, &pb.User{Id: 1})
+	}
+
+	received := 0
+	for range ch {
+		received++
+	}
+
+	assert.Equal(t, subscriberBufferSize, received)
+
+	events.unsubscribe(ch)
+}
+
+func TestUserEvents_Shutdown_RejectsNewWatchers(t *testing.T) {
+	events := newUserEvents(10)
+	events.shutdown()
+	events.shutdown()
+
+	_, _, err := events.subscribe(0)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func TestGracefulStop_EndsOpenWatchStreams(t *testing.T) {
+	listener := bufconn.Listen(1 << 20)
+	server := &userServiceServer{Store: newMemoryStore(), events: newUserEvents(10)}
+
+	grpcServer := grpc.NewServer(grpc.ChainStreamInterceptor(server.authStreamInterceptor))
+	pb.RegisterUserServiceServer(grpcServer, server)
+	go grpcServer.Serve(listener)
+
+	created, err := server.CreateUser(context.Background(), &pb.CreateUserRequest{User: &pb.User{FirstName: "Cool", LastName: "Kid", Age: 10}})
+	require.NoError(t, err)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	stream, err := pb.NewUserServiceClient(conn).WatchUsers(withBearer(created.Token), &pb.WatchUsersRequest{})
+	require.NoError(t, err)
+
+	server.events.shutdown()
+	assert.True(t, gracefulStop(grpcServer, 5*time.Second))
+
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func TestWatchUsers_RequiresPrincipalAndFiltersEvents(t *testing.T) {
+	server := &userServiceServer{Store: newMemoryStore(), events: newUserEvents(10)}
+	client := newAuthTestClient(t, server)
+	ctx := context.Background()
+
+	alice, err := client.CreateUser(ctx, &pb.CreateUserRequest{User: &pb.User{FirstName: "Alice", LastName: "Kid", Age: 10}})
+	require.NoError(t, err)
+	bob, err := client.CreateUser(ctx, &pb.CreateUserRequest{User: &pb.User{FirstName: "Bob", LastName: "Kid", Age: 11}})
+	require.NoError(t, err)
+
+	anonymous, err := client.WatchUsers(ctx, &pb.WatchUsersRequest{})
+	require.NoError(t, err)
+	_, err = anonymous.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	stream, err := client.WatchUsers(withBearer(bob.Token), &pb.WatchUsersRequest{SinceSequence: 2})
+	require.NoError(t, err)
+
+	_, err = client.PatchUser(withBearer(alice.Token), &pb.PatchUserRequest{
+		Id:         alice.User.Id,
+		User:       &pb.User{Age: 20, Version: 1},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"age"}},
+	})
+	require.NoError(t, err)
+	_, err = client.PatchUser(withBearer(bob.Token), &pb.PatchUserRequest{
+		Id:         bob.User.Id,
+		User:       &pb.User{Age: 21, Version: 1},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"age"}},
+	})
+	require.NoError(t, err)
+
+	event, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, bob.User.Id, event.User.Id, "Expected the events of other users to be skipped")
+	assert.Equal(t, int32(21), event.User.Age)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	pb "google.golang.org/grpc/examples/helloworld/helloworld"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// pinger is implemented by stores backed by a database connection pool.
+type pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// watchHealth reports the user service, and the server as a whole, as
+// serving while the store answers pings. It checks once immediately and then
+// every interval until ctx is done.
+func watchHealth(ctx context.Context, hs *health.Server, store UserStore, interval, timeout time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	serving := healthpb.HealthCheckResponse_UNKNOWN
+
+	for {
+		next := checkHealth(ctx, store, timeout)
+		if next != serving {
+			slog.Info("health changed", "service", pb.UserService_ServiceDesc.ServiceName, "status", next.String())
+			serving = next
+		}
+
+		hs.SetServingStatus("", next)
+		hs.SetServingStatus(pb.UserService_ServiceDesc.ServiceName, next)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func checkHealth(ctx context.Context, store UserStore, timeout time.Duration) healthpb.HealthCheckResponse_ServingStatus {
+	p, ok := store.(pinger)
+	if !ok {
+		return healthpb.HealthCheckResponse_SERVING
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if err := p.Ping(ctx); err != nil {
+		return healthpb.HealthCheckResponse_NOT_SERVING
+	}
+
+	return healthpb.HealthCheckResponse_SERVING
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	pb "google.golang.org/grpc/examples/helloworld/helloworld"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+type fakePinger struct {
+	*memoryStore
+	err error
+}
+
+func (p *fakePinger) Ping(ctx context.Context) error {
+	return p.err
+}
+
+func TestCheckHealth_FollowsStorePing(t *testing.T) {
+	ctx := context.Background()
+
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, checkHealth(ctx, newMemoryStore(), time.Second))
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, checkHealth(ctx, &fakePinger{memoryStore: newMemoryStore()}, time.Second))
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, checkHealth(ctx, &fakePinger{memoryStore: newMemoryStore(), err: errors.New("down")}, time.Second))
+}
+
+func TestWatchHealth_SetsServiceStatus(t *testing.T) {
+	store := newMigratedSQLiteStore(t)
+	hs := health.NewServer()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		watchHealth(ctx, hs, store, 10*time.Millisecond, time.Second)
+		close(done)
+	}()
+
+	check := func() healthpb.HealthCheckResponse_ServingStatus {
+		res, err := hs.Check(context.Background(), &healthpb.HealthCheckRequest{Service: pb.UserService_ServiceDesc.ServiceName})
+		if err != nil {
+			return healthpb.HealthCheckResponse_UNKNOWN
+		}
+		return res.Status
+	}
+
+	require.Eventually(t, func() bool { return check() == healthpb.HealthCheckResponse_SERVING }, time.Second, 5*time.Millisecond)
+
+	require.NoError(t, store.Close())
+	require.Eventually(t, func() bool { return check() == healthpb.HealthCheckResponse_NOT_SERVING }, time.Second, 5*time.Millisecond)
+
+	cancel()
+	<-done
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	pb "google.golang.org/grpc/examples/helloworld/helloworld"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const idempotencyKeyHeader = "idempotency-key"
+
+// idempotencyCache remembers the first CreateUserResponse for each
+// idempotency key so retried requests get the same user back instead of a
+// duplicate. Credentials are not kept: replayed responses carry the user but
+// no token or access token, so an Idempotency-Key cannot be used to obtain
+// credentials for someone else's account.
+type idempotencyCache struct {
+	mu      sync.Mutex
+	window  time.Duration
+	entries map[string]*idempotencyEntry
+	now     func() time.Time
+}
+
+type idempotencyEntry struct {
+	hash     [sha256.Size]byte
+	expires  time.Time
+	done     chan struct{}
+	response *pb.CreateUserResponse
+}
+
+func newIdempotencyCache(window time.Duration) *idempotencyCache {
+	return &idempotencyCache{
+		window:  window,
+		entries: make(map[string]*idempotencyEntry),
+		now:     time.Now,
+	}
+}
+
+func idempotencyKey(ctx context.Context) string {
+	return incomingHeader(ctx, idempotencyKeyHeader)
+}
+
+// do runs create once per key and window. Repeats with the same request get
+// the stored response; repeats with a different request are rejected.
+func (c *idempotencyCache) do(ctx context.Context, key string, req proto.Message, create func() (*pb.CreateUserResponse, error)) (*pb.CreateUserResponse, error) {
+	if c == nil || key == "" {
+		return create()
+	}
+
+	body, err := proto.MarshalOptions{Deterministic: true}.Marshal(req)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	hash := sha256.Sum256(body)
+
+	for {
+		c.mu.Lock()
+		c.evictExpired()
+
+		entry, ok := c.entries[key]
+		if !ok {
+			entry = &idempotencyEntry{hash: hash, done: make(chan struct{})}
+			c.entries[key] = entry
+			c.mu.Unlock()
+
+			return c.run(key, entry, create)
+		}
+		c.mu.Unlock()
+
+		if entry.hash != hash {
+			return nil, status.Errorf(codes.FailedPrecondition, "Idempotency key was already used with a different request")
+		}
+
+		select {
+		case <-entry.done:
+		case <-ctx.Done():
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+
+		if entry.response != nil {
+			return proto.Clone(entry.response).(*pb.CreateUserResponse), nil
+		}
+		// The first attempt failed and released the key; try again.
+	}
+}
+
+func (c *idempotencyCache) run(key string, entry *idempotencyEntry, create func() (*pb.CreateUserResponse, error)) (*pb.CreateUserResponse, error) {
+	response, err := create()
+
+	c.mu.Lock()
+	if err != nil {
+		delete(c.entries, key)
+	} else {
+		entry.response = proto.Clone(response).(*pb.CreateUserResponse)
+		entry.response.Token = ""
+		entry.response.TokenExpiresAt = nil
+		entry.response.AccessToken = ""
+		entry.response.AccessTokenExpiresAt = nil
+		entry.expires = c.now().Add(c.window)
+	}
+	c.mu.Unlock()
+
+	close(entry.done)
+
+	return response, err
+}
+
+func (c *idempotencyCache) evictExpired() {
+	now := c.now()
+
+	for key, entry := range c.entries {
+		if entry.response != nil && now.After(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+}
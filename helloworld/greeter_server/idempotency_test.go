@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	pb "google.golang.org/grpc/examples/helloworld/helloworld"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestIdempotencyCache_SameKeyAndBody_ReplaysResponseWithoutTokens(t *testing.T) {
+	cache := newIdempotencyCache(time.Hour)
+	req := &pb.CreateUserRequest{User: &pb.User{FirstName: "Cool", LastName: "Kid", Age: 10}}
+
+	calls := 0
+	create := func() (*pb.CreateUserResponse, error) {
+		calls++
+		return &pb.CreateUserResponse{User: &pb.User{Id: 7}, Token: "first", AccessToken: "jwt"}, nil
+	}
+
+	first, err := cache.do(context.Background(), "key", req, create)
+	assert.NoError(t, err)
+
+	second, err := cache.do(context.Background(), "key", req, create)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, first.User.Id, second.User.Id)
+	assert.Equal(t, "first", first.Token)
+	assert.Empty(t, second.Token)
+	assert.Empty(t, second.AccessToken)
+}
+
+func TestCreateUser_Replay_ReturnsUserWithoutTokens(t *testing.T) {
+	server := &userServiceServer{Store: newMemoryStore(), idempotency: newIdempotencyCache(time.Hour)}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(idempotencyKeyHeader, "abc"))
+	req := &pb.CreateUserRequest{User: &pb.User{FirstName: "Cool", LastName: "Kid", Age: 10}}
+
+	first, err := server.CreateUser(ctx, req)
+	require.NoError(t, err)
+	require.NotEmpty(t, first.Token)
+
+	second, err := server.CreateUser(ctx, req)
+	require.NoError(t, err)
+
+	assert.Equal(t, first.User.Id, second.User.Id)
+	assert.Empty(t, second.Token, "Expected a replay not to hand out credentials")
+	assert.Nil(t, second.TokenExpiresAt)
+	assert.Empty(t, second.AccessToken)
+
+	list, err := server.Store.List(context.Background(), userQuery{})
+	require.NoError(t, err)
+	assert.Len(t, list, 1)
+}
+
+func TestIdempotencyCache_SameKeyDifferentBody_ReturnsError(t *testing.T) {
+	cache := newIdempotencyCache(time.Hour)
+
+	create := func() (*pb.CreateUserResponse, error) {
+		return &pb.CreateUserResponse{Token: "first"}, nil
+	}
+
+	_, err := cache.do(context.Background(), "key", &pb.CreateUserRequest{User: &pb.User{FirstName: "Cool", LastName: "Kid", Age: 10}}, create)
+	assert.NoError(t, err)
+
+	resp, err := cache.do(context.Background(), "key", &pb.CreateUserRequest{User: &pb.User{FirstName: "Cool", LastName: "Kid", Age: 11}}, create)
+
+	assert.Nil(t, resp)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+func TestIdempotencyCache_FailedAttempt_IsNotStored(t *testing.T) {
+	cache := newIdempotencyCache(time.Hour)
+	req := &pb.CreateUserRequest{User: &pb.User{FirstName: "Cool", LastName: "Kid", Age: 10}}
+
+	_, err := cache.do(context.Background(), "key", req, func() (*pb.CreateUserResponse, error) {
+		return nil, errors.New("db down")
+	})
+	assert.Error(t, err)
+
+	resp, err := cache.do(context.Background(), "key", req, func() (*pb.CreateUserResponse, error) {
+		return &pb.CreateUserResponse{Token: "second"}, nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "second", resp.Token)
+}
+
+func TestIdempotencyCache_ExpiredKey_RunsAgain(t *testing.T) {
+	now := time.Now()
+	cache := newIdempotencyCache(time.Minute)
+	cache.now = func() time.Time { return now }
+	req := &pb.CreateUserRequest{User: &pb.User{FirstName: "Cool", LastName: "Kid", Age: 10}}
+
+	calls := 0
+	create := func() (*pb.CreateUserResponse, error) {
+		calls++
+		return &pb.CreateUserResponse{}, nil
+	}
+
+	cache.do(context.Background(), "key", req, create)
+	now = now.Add(2 * time.Minute)
+	cache.do(context.Background(), "key", req, create)
+
+	assert.Equal(t, 2, calls)
+}
+
+func TestIdempotencyKey_ReadsIncomingMetadata(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("Idempotency-Key", "abc"))
+
+	assert.Equal(t, "abc", idempotencyKey(ctx))
+	assert.Equal(t, "", idempotencyKey(context.Background()))
+}
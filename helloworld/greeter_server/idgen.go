@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	snowflakeNodeBits     = 10
+	snowflakeSequenceBits = 12
+	snowflakeMaxNode      = 1<<snowflakeNodeBits - 1
+	snowflakeMaxSequence  = 1<<snowflakeSequenceBits - 1
+)
+
+// snowflakeEpoch keeps generated ids small; it must never change once ids
+// have been issued.
+var snowflakeEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// snowflake generates time-ordered 63-bit ids from a millisecond timestamp,
+// a node id and a per-millisecond sequence, so several servers can assign ids
+// without coordinating through the database.
+type snowflake struct {
+	mu       sync.Mutex
+	node     int64
+	last     int64
+	sequence int64
+	now      func() time.Time
+}
+
+func newSnowflake(node int64) (*snowflake, error) {
+	if node < 0 || node > snowflakeMaxNode {
+		return nil, fmt.Errorf("node id %d out of range 0-%d", node, snowflakeMaxNode)
+	}
+
+	return &snowflake{node: node, now: time.Now}, nil
+}
+
+func (g *snowflake) next() int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ms := g.now().Sub(snowflakeEpoch).Milliseconds()
+
+	// Never go backwards if the clock does.
+	if ms < g.last {
+		ms = g.last
+	}
+
+	if ms == g.last {
+		g.sequence = (g.sequence + 1) & snowflakeMaxSequence
+		if g.sequence == 0 {
+			for ms <= g.last {
+				time.Sleep(time.Millisecond)
+				ms = g.now().Sub(snowflakeEpoch).Milliseconds()
+			}
+		}
+	} else {
+		g.sequence = 0
+	}
+
+	g.last = ms
+
+	return ms<<(snowflakeNodeBits+snowflakeSequenceBits) | g.node<<snowflakeSequenceBits | g.sequence
+}
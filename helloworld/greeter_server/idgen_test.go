@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewSnowflake_NodeOutOfRange_ReturnsError(t *testing.T) {
+	_, err := newSnowflake(-1)
+	assert.Error(t, err)
+
+	_, err = newSnowflake(snowflakeMaxNode + 1)
+	assert.Error(t, err)
+}
+
+func TestSnowflake_Next_IsUniqueAndIncreasing(t *testing.T) {
+	ids, err := newSnowflake(1)
+	assert.NoError(t, err)
+
+	var last int64
+	for i := 0; i < 10000; i++ {
+		id := ids.next()
+		assert.Greater(t, id, last)
+		last = id
+	}
+}
+
+func TestSnowflake_ClockMovesBack_StaysIncreasing(t *testing.T) {
+	now := time.Now()
+
+	ids, err := newSnowflake(1)
+	assert.NoError(t, err)
+	ids.now = func() time.Time { return now }
+
+	first := ids.next()
+	now = now.Add(-time.Second)
+	second := ids.next()
+
+	assert.Greater(t, second, first)
+}
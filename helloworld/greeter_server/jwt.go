@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// signingKey is one private key of the JWT key directory. Its id is the file
+// name without the .pem extension.
+type signingKey struct {
+	ID     string
+	Method jwt.SigningMethod
+	Key    crypto.Signer
+}
+
+// jwk is the public half of a signing key as a JSON Web Key.
+func (k *signingKey) jwk() map[string]string {
+	jwk := map[string]string{
+		"kid": k.ID,
+		"alg": k.Method.Alg(),
+		"use": "sig",
+	}
+
+	switch public := k.Key.Public().(type) {
+	case ed25519.PublicKey:
+		jwk["kty"] = "OKP"
+		jwk["crv"] = "Ed25519"
+		jwk["x"] = base64.RawURLEncoding.EncodeToString(public)
+	case *rsa.PublicKey:
+		jwk["kty"] = "RSA"
+		jwk["n"] = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk["e"] = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	}
+
+	return jwk
+}
+
+// jwtKeySet holds the keys of a directory of PEM Ed25519 or RSA private keys.
+// Every key verifies tokens and the last one by file name signs new ones, so
+// a key is rotated by adding a file that sorts after it and removing the old
+// file once the tokens it signed have expired.
+type jwtKeySet struct {
+	dir string
+
+	mu      sync.RWMutex
+	keys    map[string]*signingKey
+	current *signingKey
+	jwks    []byte
+}
+
+func loadJWTKeys(dir string) (*jwtKeySet, error) {
+	k := &jwtKeySet{dir: dir}
+	if _, err := k.reload(); err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// reload reads the directory again and reports whether the key ids changed.
+// A directory without usable keys keeps the previous ones.
+func (k *jwtKeySet) reload() (bool, error) {
+	paths, err := filepath.Glob(filepath.Join(k.dir, "*.pem"))
+	if err != nil {
+		return false, err
+	}
+
+	if len(paths) == 0 {
+		return false, fmt.Errorf("jwt-key-dir %s: no *.pem keys", k.dir)
+	}
+
+	sort.Strings(paths)
+
+	keys := make(map[string]*signingKey, len(paths))
+	var current *signingKey
+	var public []map[string]string
+
+	for _, path := range paths {
+		key, err := readSigningKey(path)
+		if err != nil {
+			return false, err
+		}
+
+		keys[key.ID] = key
+		current = key
+		public = append(public, key.jwk())
+	}
+
+	jwks, err := json.Marshal(map[string]any{"keys": public})
+	if err != nil {
+		return false, err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	changed := k.current == nil || k.current.ID != current.ID || !slices.Equal(sortedKeys(k.keys), sortedKeys(keys))
+	k.keys, k.current, k.jwks = keys, current, jwks
+
+	return changed, nil
+}
+
+func sortedKeys(keys map[string]*signingKey) []string {
+	ids := make([]string, 0, len(keys))
+	for id := range keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func readSigningKey(path string) (*signingKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", path)
+	}
+
+	var parsed any
+	if block.Type == "RSA PRIVATE KEY" {
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else {
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	key := &signingKey{ID: strings.TrimSuffix(filepath.Base(path), ".pem")}
+
+	switch parsed := parsed.(type) {
+	case ed25519.PrivateKey:
+		key.Method, key.Key = jwt.SigningMethodEdDSA, parsed
+	case *rsa.PrivateKey:
+		if parsed.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("%s: RSA keys must have at least 2048 bits", path)
+		}
+		key.Method, key.Key = jwt.SigningMethodRS256, parsed
+	default:
+		return nil, fmt.Errorf("%s: %T keys are not supported, use Ed25519 or RSA", path, parsed)
+	}
+
+	return key, nil
+}
+
+// Watch reloads the keys every interval until ctx is done. A failed reload
+// keeps the previous keys.
+func (k *jwtKeySet) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		changed, err := k.reload()
+		if err != nil {
+			slog.Error("Failed to reload JWT signing keys", "err", err)
+			continue
+		}
+
+		if changed {
+			slog.Info("reloaded JWT signing keys", "signing_kid", k.signer().ID)
+		}
+	}
+}
+
+func (k *jwtKeySet) signer() *signingKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.current
+}
+
+func (k *jwtKeySet) lookup(id string) (*signingKey, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok := k.keys[id]
+	return key, ok
+}
+
+// JWKS returns the public keys as a JSON Web Key Set.
+func (k *jwtKeySet) JWKS() []byte {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.jwks
+}
+
+// jwtIssuer issues and verifies the access tokens of users. The subject is
+// the user id. Access tokens are verified without the database, so they stay
+// valid until they expire even if the user is deleted.
+type jwtIssuer struct {
+	keys   *jwtKeySet
+	issuer string
+	ttl    time.Duration
+	now    func() time.Time
+}
+
+func newJWTIssuer(keys *jwtKeySet, issuer string, ttl time.Duration) *jwtIssuer {
+	return &jwtIssuer{keys: keys, issuer: issuer, ttl: ttl, now: time.Now}
+}
+
+func (i *jwtIssuer) issue(userID int64) (string, time.Time, error) {
+	key := i.keys.signer()
+	now := i.now()
+	expires := now.Add(i.ttl)
+
+	token := jwt.NewWithClaims(key.Method, jwt.RegisteredClaims{
+		Issuer:    i.issuer,
+		Subject:   strconv.FormatInt(userID, 10),
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(expires),
+	})
+	token.Header["kid"] = key.ID
+
+	signed, err := token.SignedString(key.Key)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return signed, expires, nil
+}
+
+func (i *jwtIssuer) verify(token string) (principal, error) {
+	var claims jwt.RegisteredClaims
+
+	_, err := jwt.ParseWithClaims(token, &claims, func(token *jwt.Token) (interface{}, error) {
+		id, _ := token.Header["kid"].(string)
+
+		key, ok := i.keys.lookup(id)
+		if !ok {
+			return nil, fmt.Errorf("unknown key id %q", id)
+		}
+
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, fmt.Errorf("key %q does not sign %s tokens", id, token.Method.Alg())
+		}
+
+		return key.Key.Public(), nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodEdDSA.Alg(), jwt.SigningMethodRS256.Alg()}),
+		jwt.WithIssuer(i.issuer),
+		jwt.WithExpirationRequired(),
+		jwt.WithTimeFunc(i.now),
+	)
+	if err != nil {
+		return principal{}, err
+	}
+
+	userID, err := strconv.ParseInt(claims.Subject, 10, 64)
+	if err != nil || userID <= 0 {
+		return principal{}, errors.New("invalid subject")
+	}
+
+	return principal{UserID: userID}, nil
+}
+
+// isJWT tells signed access tokens from opaque user tokens, which have no
+// dots.
+func isJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
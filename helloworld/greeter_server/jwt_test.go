@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	pb "google.golang.org/grpc/examples/helloworld/helloworld"
+	"google.golang.org/grpc/status"
+)
+
+func writeSigningKey(t *testing.T, dir, name string, key any) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600))
+}
+
+func newEd25519Key(t *testing.T) ed25519.PrivateKey {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	return key
+}
+
+func TestJWTIssuer_IssueAndVerify(t *testing.T) {
+	dir := t.TempDir()
+	writeSigningKey(t, dir, "2024-01.pem", newEd25519Key(t))
+
+	keys, err := loadJWTKeys(dir)
+	require.NoError(t, err)
+	issuer := newJWTIssuer(keys, "greeter_server", time.Minute)
+
+	token, expires, err := issuer.issue(42)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), expires, 5*time.Second)
+	assert.True(t, isJWT(token))
+
+	p, err := issuer.verify(token)
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), p.UserID)
+
+	other := newJWTIssuer(keys, "someone_else", time.Minute)
+	_, err = other.verify(token)
+	assert.Error(t, err, "Expected tokens of another issuer to be rejected")
+
+	issuer.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	_, err = issuer.verify(token)
+	assert.Error(t, err, "Expected expired tokens to be rejected")
+}
+
+func TestJWTKeySet_Rotation(t *testing.T) {
+	dir := t.TempDir()
+	writeSigningKey(t, dir, "2024-01.pem", newEd25519Key(t))
+
+	keys, err := loadJWTKeys(dir)
+	require.NoError(t, err)
+	issuer := newJWTIssuer(keys, "greeter_server", time.Minute)
+
+	old, _, err := issuer.issue(1)
+	require.NoError(t, err)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	writeSigningKey(t, dir, "2024-02.pem", rsaKey)
+
+	changed, err := keys.reload()
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, "2024-02", keys.signer().ID)
+
+	current, _, err := issuer.issue(2)
+	require.NoError(t, err)
+
+	_, err = issuer.verify(old)
+	assert.NoError(t, err, "Expected tokens of the previous key to verify until it is removed")
+	_, err = issuer.verify(current)
+	assert.NoError(t, err)
+
+	var jwks struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	require.NoError(t, json.Unmarshal(keys.JWKS(), &jwks))
+	require.Len(t, jwks.Keys, 2)
+	assert.Equal(t, "2024-01", jwks.Keys[0]["kid"])
+	assert.Equal(t, "OKP", jwks.Keys[0]["kty"])
+	assert.Equal(t, "EdDSA", jwks.Keys[0]["alg"])
+	assert.NotEmpty(t, jwks.Keys[0]["x"])
+	assert.Equal(t, "2024-02", jwks.Keys[1]["kid"])
+	assert.Equal(t, "RSA", jwks.Keys[1]["kty"])
+	assert.Equal(t, "RS256", jwks.Keys[1]["alg"])
+	assert.Equal(t, "AQAB", jwks.Keys[1]["e"])
+	assert.NotContains(t, jwks.Keys[1], "d", "Expected only public keys")
+
+	require.NoError(t, os.Remove(filepath.Join(dir, "2024-01.pem")))
+	_, err = keys.reload()
+	require.NoError(t, err)
+
+	_, err = issuer.verify(old)
+	assert.Error(t, err, "Expected tokens of a removed key to be rejected")
+}
+
+func TestLoadJWTKeys_RejectsEmptyDirAndWeakKeys(t *testing.T) {
+	_, err := loadJWTKeys(t.TempDir())
+	assert.Error(t, err)
+
+	dir := t.TempDir()
+	weak, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	writeSigningKey(t, dir, "weak.pem", weak)
+
+	_, err = loadJWTKeys(dir)
+	assert.ErrorContains(t, err, "2048")
+}
+
+func TestAuthInterceptor_AccessToken_AuthenticatesWithoutStore(t *testing.T) {
+	dir := t.TempDir()
+	writeSigningKey(t, dir, "current.pem", newEd25519Key(t))
+
+	keys, err := loadJWTKeys(dir)
+	require.NoError(t, err)
+
+	server := &userServiceServer{Store: newMemoryStore(), events: newUserEvents(10), jwt: newJWTIssuer(keys, "greeter_server", time.Minute)}
+	client := newAuthTestClient(t, server)
+
+	created, err := client.CreateUser(context.Background(), &pb.CreateUserRequest{User: &pb.User{FirstName: "Cool", LastName: "Kid", Age: 10}})
+	require.NoError(t, err)
+	require.NotEmpty(t, created.AccessToken)
+	assert.True(t, created.AccessTokenExpiresAt.AsTime().After(time.Now()))
+
+	_, err = client.GetUser(withBearer(created.AccessToken), &pb.GetUserRequest{Id: created.User.Id})
+	assert.NoError(t, err)
+
+	_, err = client.GetUser(withBearer(created.AccessToken+"x"), &pb.GetUserRequest{Id: created.User.Id})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	jwks, err := client.GetJWKS(context.Background(), &pb.GetJWKSRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, "application/json", jwks.ContentType)
+	assert.JSONEq(t, string(keys.JWKS()), string(jwks.Data))
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/examples/helloworld/internal/logging"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// withRequestID takes the request ID forwarded by the gateway, or assigns
+// one, stores it in the context and returns it in the response headers.
+func withRequestID(ctx context.Context) context.Context {
+	var id string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(logging.MetadataKey); len(values) > 0 {
+			id = values[0]
+		}
+	}
+
+	id = logging.RequestIDOrNew(id)
+	grpc.SetHeader(ctx, metadata.Pairs(logging.MetadataKey, id))
+
+	return logging.WithRequestID(ctx, id)
+}
+
+// logRPC logs the outcome of an RPC and attaches the request ID to errors
+// so clients can quote it.
+func logRPC(ctx context.Context, method string, start time.Time, err error) error {
+	code := status.Code(err)
+
+	level := slog.LevelInfo
+	switch code {
+	case codes.OK:
+	case codes.Internal, codes.Unknown, codes.DataLoss, codes.Unavailable:
+		level = slog.LevelError
+	default:
+		level = slog.LevelWarn
+	}
+
+	attrs := []any{"method", method, "code", code.String(), "duration", time.Since(start)}
+	if err != nil {
+		attrs = append(attrs, "err", err)
+	}
+	slog.Log(ctx, level, "rpc finished", attrs...)
+
+	if err == nil {
+		return nil
+	}
+
+	st, err2 := status.Convert(err).WithDetails(&errdetails.RequestInfo{RequestId: logging.RequestID(ctx)})
+	if err2 != nil {
+		return err
+	}
+	return st.Err()
+}
+
+func loggingUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	ctx = withRequestID(ctx)
+
+	resp, err := handler(ctx, req)
+
+	return resp, logRPC(ctx, info.FullMethod, start, err)
+}
+
+// contextStream replaces the context of a server stream.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
+
+func loggingStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	ctx := withRequestID(ss.Context())
+
+	err := handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+
+	return logRPC(ctx, info.FullMethod, start, err)
+}
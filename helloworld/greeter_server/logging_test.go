@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/examples/helloworld/internal/logging"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestLoggingUnaryInterceptor_UsesForwardedRequestID(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(logging.MetadataKey, "req-42"))
+	info := &grpc.UnaryServerInfo{FullMethod: "/helloworld.UserService/GetUser"}
+
+	var seen string
+	_, err := loggingUnaryInterceptor(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		seen = logging.RequestID(ctx)
+		return nil, status.Error(codes.NotFound, "user not found")
+	})
+
+	assert.Equal(t, "req-42", seen)
+
+	st := status.Convert(err)
+	assert.Equal(t, codes.NotFound, st.Code())
+	assert.Equal(t, "user not found", st.Message())
+	require.Len(t, st.Details(), 1)
+	assert.Equal(t, "req-42", st.Details()[0].(*errdetails.RequestInfo).RequestId)
+}
+
+func TestLoggingUnaryInterceptor_AssignsRequestID(t *testing.T) {
+	info := &grpc.UnaryServerInfo{FullMethod: "/helloworld.UserService/GetUser"}
+
+	var seen string
+	resp, err := loggingUnaryInterceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		seen = logging.RequestID(ctx)
+		return "ok", nil
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, "ok", resp)
+	assert.Len(t, seen, 36)
+}
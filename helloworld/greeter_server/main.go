@@ -2,106 +2,444 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
 	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
-	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/genproto/googleapis/api/httpbody"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	pb "google.golang.org/grpc/examples/helloworld/helloworld"
+	"google.golang.org/grpc/examples/helloworld/internal/config"
+	"google.golang.org/grpc/examples/helloworld/internal/logging"
+	"google.golang.org/grpc/examples/helloworld/internal/tlsconfig"
+	"google.golang.org/grpc/examples/helloworld/internal/tracing"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/status"
-	"gorm.io/driver/postgres"
-	"gorm.io/gorm"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
-var addr string = "0.0.0.0:50051"
+var (
+	addr              = flag.String("addr", "0.0.0.0:50051", "address the gRPC server listens on")
+	printConfig       = flag.Bool("print-config", false, "print the effective configuration and exit")
+	dbHost            = flag.String("db-host", "localhost", "postgres host")
+	dbPort            = flag.Int("db-port", 5433, "postgres port")
+	dbUser            = flag.String("db-user", "postgres", "postgres user")
+	dbPassword        = flag.String("db-password", "", "postgres password; prefer GREETER_SERVER_DB_PASSWORD_FILE")
+	dbName            = flag.String("db-name", "UserDB", "postgres database name")
+	dbSSLMode         = flag.String("db-sslmode", "disable", "postgres sslmode")
+	tlsCert           = flag.String("tls-cert", "", "PEM certificate the server presents; enables TLS")
+	tlsKey            = flag.String("tls-key", "", "PEM private key for tls-cert")
+	tlsClientCA       = flag.String("tls-client-ca", "", "PEM CA bundle; when set clients must present a certificate it signed (mTLS)")
+	tlsAllowedClients = flag.String("tls-allowed-clients", "", "comma-separated client certificate common or DNS names allowed to connect; empty allows any")
+	tlsReload         = flag.Duration("tls-reload-interval", 30*time.Second, "how often TLS files are checked for changes")
+	traceExporter     = flag.String("trace-exporter", "none", "where spans are sent: none, otlp, stdout or file")
+	traceEndpoint     = flag.String("trace-otlp-endpoint", "", "OTLP gRPC collector address; defaults to OTEL_EXPORTER_OTLP_ENDPOINT")
+	traceInsecure     = flag.Bool("trace-otlp-insecure", false, "send spans to the collector without TLS")
+	traceFile         = flag.String("trace-file", "traces.json", "file the file exporter appends JSON spans to")
+	traceSampleRatio  = flag.Float64("trace-sample-ratio", 1, "fraction of new traces that are recorded")
+	logFormat         = flag.String("log-format", "text", "log output format: text or json")
+	logLevel          = flag.String("log-level", "info", "minimum log level: debug, info, warn or error")
+	metricsAddr       = flag.String("metrics-addr", "0.0.0.0:9090", "address serving Prometheus metrics on /metrics; empty disables it")
+	healthInterval    = flag.Duration("health-interval", 5*time.Second, "how often the database is pinged to update the health service")
+	shutdownTimeout   = flag.Duration("shutdown-timeout", 30*time.Second, "how long in-flight RPCs may run after SIGINT or SIGTERM before they are cut off")
+	storeKind         = flag.String("store", "postgres", "where users are stored: postgres, sqlite or memory")
+	sqlitePath        = flag.String("sqlite-path", "users.db", "database file for the sqlite store")
+	idempotencyWindow = flag.Duration("idempotency-window", 24*time.Hour, "how long CreateUser responses are replayed for a repeated Idempotency-Key")
+	idStrategy        = flag.String("id-strategy", "serial", "how user ids are assigned: serial (database sequence) or snowflake")
+	nodeID            = flag.Int64("node-id", 0, "snowflake node id, unique per server instance (0-1023)")
+	jwtKeyDir         = flag.String("jwt-key-dir", "", "directory of PEM Ed25519 or RSA private keys; enables JWT access tokens. The last *.pem by name signs, every one verifies")
+	jwtKeyReload      = flag.Duration("jwt-key-reload-interval", time.Minute, "how often jwt-key-dir is read again for rotated keys")
+	jwtIssuerName     = flag.String("jwt-issuer", "greeter_server", "iss claim of issued access tokens, required when verifying them")
+	jwtTTL            = flag.Duration("jwt-ttl", 15*time.Minute, "lifetime of access tokens; they stay valid until then even if the user is deleted")
+	tokenTTL          = flag.Duration("token-ttl", 90*24*time.Hour, "lifetime of user tokens; 0 never expires them")
+	tokenPepper       = flag.String("token-pepper", "", "secret key user tokens are hashed with; prefer GREETER_SERVER_TOKEN_PEPPER_FILE. Changing it invalidates every token")
+	passwordMinLength = flag.Int("password-min-length", 12, "minimum number of characters in a password")
+	passwordMaxLength = flag.Int("password-max-length", 128, "maximum number of characters in a password")
+	adminUserIDs      = flag.String("admin-user-ids", "", "comma-separated ids of users that may list and watch every user")
+	passwordMinClass  = flag.Int("password-min-classes", 1, "how many of lowercase, uppercase, digits and other characters a password must mix (1-4)")
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 1000
+	batchSize       = 500
+)
+
+// usernamePattern keeps usernames distinct from ids and safe in URLs.
+var usernamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9._-]{2,63}$`)
+
+var orderByColumns = map[string]string{
+	"id":         "id",
+	"first_name": "first_name",
+	"last_name":  "last_name",
+	"age":        "age",
+	"created_at": "created_at",
+}
 
 type userServiceServer struct {
-	DB *gorm.DB
+	Store       UserStore
+	events      *userEvents
+	idempotency *idempotencyCache
+	ids         *snowflake
+	jwt         *jwtIssuer
+	tokenPepper []byte
+	tokenTTL    time.Duration
+	passwords   passwordPolicy
+	admins      map[int64]bool
 	pb.UserServiceServer
 }
 
-type User struct {
-	gorm.Model
-	id         int64
-	First_name string
-	Last_name  string
-	Age        int32
-	Token      string
+// dataSourceName builds the postgres DSN from the db-* settings.
+func dataSourceName() string {
+	quote := func(value string) string {
+		return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+	}
+
+	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+		quote(*dbHost), *dbPort, quote(*dbUser), quote(*dbPassword), quote(*dbName), quote(*dbSSLMode))
 }
 
-func initialize(dsn string) *gorm.DB {
-	DB, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+func validateConfig() error {
+	var errs []error
+
+	if _, _, err := net.SplitHostPort(*addr); err != nil {
+		errs = append(errs, fmt.Errorf("addr: %w", err))
+	}
+
+	switch *storeKind {
+	case "postgres":
+		if *dbHost == "" || *dbUser == "" || *dbName == "" {
+			errs = append(errs, errors.New("db-host, db-user and db-name are required for the postgres store"))
+		}
+		if *dbPort <= 0 || *dbPort > 65535 {
+			errs = append(errs, fmt.Errorf("db-port: %d is not a valid port", *dbPort))
+		}
+	case "sqlite":
+		if *sqlitePath == "" {
+			errs = append(errs, errors.New("sqlite-path is required for the sqlite store"))
+		}
+	case "memory":
+	default:
+		errs = append(errs, fmt.Errorf("store: unknown store %q", *storeKind))
+	}
+
+	if (*storeKind == "postgres" || *storeKind == "sqlite") && *tokenPepper == "" {
+		errs = append(errs, fmt.Errorf("token-pepper is required for the %s store", *storeKind))
+	}
+
+	switch *idStrategy {
+	case "serial":
+	case "snowflake":
+		if *nodeID < 0 || *nodeID > snowflakeMaxNode {
+			errs = append(errs, fmt.Errorf("node-id: must be between 0 and %d", snowflakeMaxNode))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("id-strategy: unknown strategy %q", *idStrategy))
+	}
+
+	tlsFiles := tlsconfig.Files{Cert: *tlsCert, Key: *tlsKey, CA: *tlsClientCA}
+	if err := tlsFiles.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if *tlsCert == "" && *tlsClientCA != "" {
+		errs = append(errs, errors.New("tls-client-ca needs tls-cert and tls-key"))
+	}
+
+	if *tlsAllowedClients != "" && *tlsClientCA == "" {
+		errs = append(errs, errors.New("tls-allowed-clients needs tls-client-ca"))
+	}
+
+	if *tlsReload <= 0 {
+		errs = append(errs, errors.New("tls-reload-interval: must be positive"))
+	}
+
+	if err := traceConfig().Validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if *metricsAddr != "" {
+		if _, _, err := net.SplitHostPort(*metricsAddr); err != nil {
+			errs = append(errs, fmt.Errorf("metrics-addr: %w", err))
+		}
+	}
+
+	if *healthInterval <= 0 {
+		errs = append(errs, errors.New("health-interval: must be positive"))
+	}
+
+	if *shutdownTimeout <= 0 {
+		errs = append(errs, errors.New("shutdown-timeout: must be positive"))
+	}
+
+	if *idempotencyWindow <= 0 {
+		errs = append(errs, errors.New("idempotency-window: must be positive"))
+	}
+
+	if *tokenTTL < 0 {
+		errs = append(errs, errors.New("token-ttl: must not be negative"))
+	}
+
+	if _, err := parseUserIDs(*adminUserIDs); err != nil {
+		errs = append(errs, fmt.Errorf("admin-user-ids: %w", err))
+	}
+
+	if *passwordMinLength < 1 {
+		errs = append(errs, errors.New("password-min-length: must be positive"))
+	}
+
+	if *passwordMaxLength < *passwordMinLength {
+		errs = append(errs, errors.New("password-max-length: must not be less than password-min-length"))
+	}
+
+	if *passwordMaxLength > maxPasswordLength {
+		errs = append(errs, fmt.Errorf("password-max-length: must be at most %d", maxPasswordLength))
+	}
+
+	if *passwordMinClass < 1 || *passwordMinClass > 4 {
+		errs = append(errs, errors.New("password-min-classes: must be between 1 and 4"))
+	}
+
+	if *jwtKeyDir != "" {
+		if *jwtIssuerName == "" {
+			errs = append(errs, errors.New("jwt-issuer: required with jwt-key-dir"))
+		}
+		if *jwtTTL <= 0 {
+			errs = append(errs, errors.New("jwt-ttl: must be positive"))
+		}
+		if *jwtKeyReload <= 0 {
+			errs = append(errs, errors.New("jwt-key-reload-interval: must be positive"))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// parseUserIDs reads a comma-separated list of user ids.
+func parseUserIDs(list string) (map[int64]bool, error) {
+	ids := make(map[int64]bool)
+
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		id, err := strconv.ParseInt(field, 10, 64)
+		if err != nil || id <= 0 {
+			return nil, fmt.Errorf("%q is not a user id", field)
+		}
+		ids[id] = true
+	}
+
+	return ids, nil
+}
+
+func traceConfig() tracing.Config {
+	return tracing.Config{
+		Exporter:    *traceExporter,
+		Endpoint:    *traceEndpoint,
+		Insecure:    *traceInsecure,
+		File:        *traceFile,
+		SampleRatio: *traceSampleRatio,
+	}
+}
+
+func openStore(kind string, dsn string) (UserStore, error) {
+	switch kind {
+	case "postgres":
+		return newPostgresStore(dsn)
+	case "sqlite":
+		return newSQLiteStore(*sqlitePath)
+	case "memory":
+		return newMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown store %q", kind)
+	}
+}
+
+func initialize(kind string, dsn string, pepper []byte) UserStore {
+	store, err := openStore(kind, dsn)
 	if err != nil {
-		log.Fatal("Error connecting to db", err)
+		logging.Fatal("Error connecting to db", "store", kind, "err", err)
+	}
+
+	if err := checkSchema(context.Background(), store); err != nil {
+		logging.Fatal("Database schema is not current", "err", err)
+	}
+
+	if n, err := hashLegacyTokens(context.Background(), store, pepper); err != nil {
+		logging.Fatal("Failed to hash stored tokens", "err", err)
+	} else if n > 0 {
+		slog.Info("hashed plaintext tokens", "users", n)
 	}
 
-	DB.AutoMigrate(&User{})
+	slog.Info("Connected to DB successfully!", "store", kind)
+	return store
+}
+
+// versionConflictReason is the ErrorInfo reason of version conflicts, which
+// the gateway answers with 412 Precondition Failed. Other Aborted errors keep
+// the default mapping.
+const versionConflictReason = "VERSION_CONFLICT"
+
+func versionConflict() error {
+	st, err := status.New(codes.Aborted, "User has been modified").WithDetails(&errdetails.ErrorInfo{
+		Reason: versionConflictReason,
+		Domain: pb.UserService_ServiceDesc.ServiceName,
+	})
+	if err != nil {
+		return status.Errorf(codes.Aborted, "User has been modified")
+	}
 
-	fmt.Println("Connected to DB successfully!")
-	return DB
+	return st.Err()
+}
+
+// storeError converts UserStore errors into the errors returned to clients.
+func storeError(err error) error {
+	switch {
+	case errors.Is(err, errUserNotFound):
+		return status.Errorf(codes.NotFound, "User not found")
+	case errors.Is(err, errUserNotCreated):
+		return status.Errorf(codes.Internal, "Cannot create user successfully")
+	case errors.Is(err, errVersionConflict):
+		return versionConflict()
+	case errors.Is(err, errUsernameTaken):
+		return status.Errorf(codes.AlreadyExists, "Username is taken")
+	default:
+		// Driver errors can name tables, columns and values, so callers only
+		// get a generic message and the cause is logged.
+		slog.Error("Store operation failed", "err", err)
+		return status.Errorf(codes.Internal, "Internal error")
+	}
+}
+
+func validateUser(user *pb.User) error {
+	if user == nil || user.GetFirstName() == "" || user.GetLastName() == "" || user.GetAge() <= 0 {
+		return status.Errorf(codes.InvalidArgument, "Invalid user data")
+	}
+
+	if username := user.GetUsername(); username != "" && !usernamePattern.MatchString(username) {
+		return status.Errorf(codes.InvalidArgument, "Invalid username")
+	}
+
+	return nil
 }
 
 func (s *userServiceServer) CreateUser(ctx context.Context, req *pb.CreateUserRequest) (*pb.CreateUserResponse, error) {
+	return s.idempotency.do(ctx, idempotencyKey(ctx), req, func() (*pb.CreateUserResponse, error) {
+		return s.createUser(ctx, req)
+	})
+}
+
+func (s *userServiceServer) createUser(ctx context.Context, req *pb.CreateUserRequest) (*pb.CreateUserResponse, error) {
 	user := req.GetUser()
 
-	if user == nil || user.GetFirstName() == "" || user.GetLastName() == "" || user.GetAge() <= 0 {
-		return nil, status.Errorf(codes.InvalidArgument, "Invalid user data")
+	if err := validateUser(user); err != nil {
+		return nil, err
 	}
 
-	token := uuid.New().String()
+	users, token := s.newUserRecord(user)
+
+	if req.Password != "" {
+		if err := s.passwords.validate(req.Password); err != nil {
+			return nil, err
+		}
 
-	users := User{
-		id:         user.Id,
-		First_name: user.FirstName,
-		Last_name:  user.LastName,
-		Age:        user.Age,
-		Token:      token,
+		release, err := acquireHashSlot(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		hash, err := hashPassword(req.Password)
+		release()
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		users.PasswordHash = hash
 	}
 
-	if s.DB == nil {
+	if s.Store == nil {
 		return nil, status.Error(codes.Internal, "Database connection is nil")
 	}
 
-	result := s.DB.Create(&users)
-
-	if result.Error != nil {
-		return nil, status.Error(codes.Internal, result.Error.Error())
+	if err := s.Store.Create(ctx, &users); err != nil {
+		return nil, storeError(err)
 	}
 
-	if result.RowsAffected == 0 {
-		return nil, errors.New("cannot create user successfully")
-	}
+	s.events.publish(pb.UserEvent_CREATED, userRecordToProto(users))
 
 	response := &pb.CreateUserResponse{
-		User:    user,
-		Token:   users.Token,
-		Message: "Created user successfully",
+		User:           userRecordToProto(users),
+		Token:          token,
+		TokenExpiresAt: optionalTimestamp(users.Tokens[0].ExpiresAt),
+		Message:        "Created user successfully",
+	}
+
+	if s.jwt != nil {
+		accessToken, expires, err := s.jwt.issue(int64(users.ID))
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+
+		response.AccessToken = accessToken
+		response.AccessTokenExpiresAt = timestamppb.New(expires)
 	}
 
 	return response, nil
 }
 
-func (s *userServiceServer) GetUser(ctx context.Context, req *pb.GetUserRequest) (*pb.GetUserResponse, error) {
-	id := req.Id
-	token := req.Token
+// newUserRecord prepares a row for a validated user with a fresh token and,
+// unless the database assigns ids, a generated id. Only the hash of the
+// returned token is kept.
+func (s *userServiceServer) newUserRecord(user *pb.User) (userRecord, string) {
+	record := userRecordFromProto(user)
+	record.Version = 1
 
-	var user *pb.User
+	if s.ids != nil {
+		record.ID = uint(s.ids.next())
+	}
+
+	token, tokenRecord := s.newUserToken(record.ID, time.Now())
+	record.Tokens = []userToken{tokenRecord}
+
+	return record, token
+}
 
-	s.DB.First(&user, id)
+func (s *userServiceServer) GetUser(ctx context.Context, req *pb.GetUserRequest) (*pb.GetUserResponse, error) {
+	id := req.Id
 
-	if user.Id == 0 {
-		return nil, errors.New("user not found")
+	user, err := s.Store.Get(ctx, id)
+	if err != nil {
+		return nil, storeError(err)
 	}
 
-	if user.Token != token {
-		return nil, status.Errorf(codes.Unauthenticated, "Unauthenticated")
+	if err := authorize(ctx, user); err != nil {
+		return nil, err
 	}
 
 	response := &pb.GetUserResponse{
-		User: user,
+		User: userRecordToProto(user),
 	}
 
 	return response, nil
@@ -110,54 +448,760 @@ func (s *userServiceServer) GetUser(ctx context.Context, req *pb.GetUserRequest)
 func (s *userServiceServer) UpdateUser(ctx context.Context, req *pb.UpdateUserRequest) (*pb.UpdateUserResponse, error) {
 	usr := req.User
 	id := req.Id
-	token := req.Token
 
-	if usr == nil || usr.GetFirstName() == "" || usr.GetLastName() == "" || usr.GetAge() <= 0 {
-		return nil, status.Errorf(codes.InvalidArgument, "Invalid user data")
+	if err := validateUser(usr); err != nil {
+		return nil, err
 	}
 
-	var user *pb.User
+	var err error
+	if usr.Version, err = requestVersion(ctx, usr.Version); err != nil {
+		return nil, err
+	}
+
+	if usr.Version <= 0 {
+		return nil, status.Errorf(codes.FailedPrecondition, "User version is required")
+	}
 
-	s.DB.First(&user, id)
+	user, err := s.Store.Get(ctx, id)
+	if err != nil {
+		return nil, storeError(err)
+	}
 
-	if user.Id == 0 {
-		return nil, errors.New("user not found")
+	if err := authorize(ctx, user); err != nil {
+		return nil, err
 	}
 
-	if user.Token != token {
-		return nil, status.Errorf(codes.Unauthenticated, "Unauthenticated")
+	if user.Version != usr.Version {
+		return nil, versionConflict()
 	}
 
 	user.FirstName = usr.FirstName
 	user.LastName = usr.LastName
 	user.Age = usr.Age
 
-	s.DB.Save(&user)
+	if err := s.Store.Update(ctx, &user, "first_name", "last_name", "age"); err != nil {
+		return nil, storeError(err)
+	}
+
+	s.events.publish(pb.UserEvent_UPDATED, userRecordToProto(user))
 
 	response := &pb.UpdateUserResponse{
-		User:    user,
+		User:    userRecordToProto(user),
+		Message: "User successfully updated",
+	}
+
+	return response, nil
+}
+
+func (s *userServiceServer) PatchUser(ctx context.Context, req *pb.PatchUserRequest) (*pb.PatchUserResponse, error) {
+	usr := req.User
+	id := req.Id
+	mask := req.UpdateMask
+
+	if usr == nil || len(mask.GetPaths()) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid user data")
+	}
+
+	for _, path := range mask.Paths {
+		switch path {
+		case "first_name":
+			if usr.FirstName == "" {
+				return nil, status.Errorf(codes.InvalidArgument, "Invalid user data")
+			}
+		case "last_name":
+			if usr.LastName == "" {
+				return nil, status.Errorf(codes.InvalidArgument, "Invalid user data")
+			}
+		case "age":
+			if usr.Age <= 0 {
+				return nil, status.Errorf(codes.InvalidArgument, "Invalid user data")
+			}
+		default:
+			return nil, status.Errorf(codes.InvalidArgument, "Invalid update mask path %q", path)
+		}
+	}
+
+	var err error
+	if usr.Version, err = requestVersion(ctx, usr.Version); err != nil {
+		return nil, err
+	}
+
+	if usr.Version <= 0 {
+		return nil, status.Errorf(codes.FailedPrecondition, "User version is required")
+	}
+
+	user, err := s.Store.Get(ctx, id)
+	if err != nil {
+		return nil, storeError(err)
+	}
+
+	if err := authorize(ctx, user); err != nil {
+		return nil, err
+	}
+
+	if user.Version != usr.Version {
+		return nil, versionConflict()
+	}
+
+	for _, path := range mask.Paths {
+		switch path {
+		case "first_name":
+			user.FirstName = usr.FirstName
+		case "last_name":
+			user.LastName = usr.LastName
+		case "age":
+			user.Age = usr.Age
+		}
+	}
+
+	if err := s.Store.Update(ctx, &user, mask.Paths...); err != nil {
+		return nil, storeError(err)
+	}
+
+	s.events.publish(pb.UserEvent_UPDATED, userRecordToProto(user))
+
+	response := &pb.PatchUserResponse{
+		User:    userRecordToProto(user),
 		Message: "User successfully updated",
 	}
 
 	return response, nil
 }
 
+func (s *userServiceServer) DeleteUser(ctx context.Context, req *pb.DeleteUserRequest) (*pb.DeleteUserResponse, error) {
+	id := req.Id
+
+	user, err := s.Store.Get(ctx, id)
+	if err != nil {
+		return nil, storeError(err)
+	}
+
+	if err := authorize(ctx, user); err != nil {
+		return nil, err
+	}
+
+	if err := s.Store.Delete(ctx, id); err != nil {
+		return nil, storeError(err)
+	}
+
+	s.events.publish(pb.UserEvent_DELETED, userRecordToProto(user))
+
+	response := &pb.DeleteUserResponse{
+		Message: "User successfully deleted",
+	}
+
+	return response, nil
+}
+
+func (s *userServiceServer) RotateToken(ctx context.Context, req *pb.RotateTokenRequest) (*pb.RotateTokenResponse, error) {
+	grace := req.GetGracePeriod().AsDuration()
+	if req.GracePeriod != nil && (!req.GracePeriod.IsValid() || grace < 0) {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid grace period")
+	}
+
+	user, err := s.Store.Get(ctx, req.Id)
+	if err != nil {
+		return nil, storeError(err)
+	}
+
+	if err := authorize(ctx, user); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	token, record := s.newUserToken(user.ID, now)
+
+	if err := s.Store.RotateToken(ctx, &record, now.Add(grace)); err != nil {
+		return nil, storeError(err)
+	}
+
+	response := &pb.RotateTokenResponse{
+		Token:     token,
+		ExpiresAt: optionalTimestamp(record.ExpiresAt),
+		Message:   "Token successfully rotated",
+	}
+
+	return response, nil
+}
+
+func (s *userServiceServer) RevokeToken(ctx context.Context, req *pb.RevokeTokenRequest) (*pb.RevokeTokenResponse, error) {
+	user, err := s.Store.Get(ctx, req.Id)
+	if err != nil {
+		return nil, storeError(err)
+	}
+
+	if err := authorize(ctx, user); err != nil {
+		return nil, err
+	}
+
+	var hash string
+	if req.TokenToRevoke != "" {
+		hash = hashToken(s.tokenPepper, req.TokenToRevoke)
+	}
+
+	revoked, err := s.Store.RevokeTokens(ctx, req.Id, hash)
+	if err != nil {
+		return nil, storeError(err)
+	}
+
+	if hash != "" && revoked == 0 {
+		return nil, status.Errorf(codes.NotFound, "Token not found")
+	}
+
+	response := &pb.RevokeTokenResponse{
+		Revoked: int32(revoked),
+		Message: "Token successfully revoked",
+	}
+
+	return response, nil
+}
+
+func (s *userServiceServer) Login(ctx context.Context, req *pb.LoginRequest) (*pb.LoginResponse, error) {
+	if err := s.passwords.validateMaxLength(req.Password); err != nil {
+		return nil, err
+	}
+
+	var user userRecord
+	var err error
+
+	switch login := req.User.(type) {
+	case *pb.LoginRequest_Id:
+		user, err = s.Store.Get(ctx, login.Id)
+	case *pb.LoginRequest_Username:
+		user, err = s.Store.FindByUsername(ctx, login.Username)
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "Id or username is required")
+	}
+
+	if err != nil && !errors.Is(err, errUserNotFound) {
+		return nil, storeError(err)
+	}
+
+	release, err := acquireHashSlot(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := user.PasswordHash
+	if hash == "" {
+		hash = dummyPasswordHash()
+	}
+
+	valid := checkPassword(hash, req.Password)
+	release()
+
+	if !valid || user.PasswordHash == "" {
+		return nil, status.Errorf(codes.Unauthenticated, "Invalid credentials")
+	}
+
+	token, record := s.newUserToken(user.ID, time.Now())
+
+	if err := s.Store.AddToken(ctx, &record); err != nil {
+		return nil, storeError(err)
+	}
+
+	response := &pb.LoginResponse{
+		Id:             int64(user.ID),
+		Token:          token,
+		TokenExpiresAt: optionalTimestamp(record.ExpiresAt),
+		Message:        "Logged in successfully",
+	}
+
+	if s.jwt != nil {
+		accessToken, expires, err := s.jwt.issue(int64(user.ID))
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+
+		response.AccessToken = accessToken
+		response.AccessTokenExpiresAt = timestamppb.New(expires)
+	}
+
+	return response, nil
+}
+
+func (s *userServiceServer) GetJWKS(ctx context.Context, req *pb.GetJWKSRequest) (*httpbody.HttpBody, error) {
+	jwks := []byte(`{"keys":[]}`)
+	if s.jwt != nil {
+		jwks = s.jwt.keys.JWKS()
+	}
+
+	return &httpbody.HttpBody{ContentType: "application/json", Data: jwks}, nil
+}
+
+func (s *userServiceServer) ListUsers(ctx context.Context, req *pb.ListUsersRequest) (*pb.ListUsersResponse, error) {
+	p, ok := principalFromContext(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "Unauthenticated")
+	}
+
+	pageSize := int(req.PageSize)
+
+	if pageSize < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid page size")
+	}
+
+	if pageSize == 0 {
+		pageSize = defaultPageSize
+	}
+
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+
+	if req.MinAge < 0 || req.MaxAge < 0 || (req.MaxAge > 0 && req.MinAge > req.MaxAge) {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid age range")
+	}
+
+	column, desc, err := parseOrderBy(req.OrderBy)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	query := userQuery{
+		Limit:    pageSize + 1,
+		LastName: req.LastName,
+		MinAge:   req.MinAge,
+		MaxAge:   req.MaxAge,
+		OrderBy:  column,
+		Desc:     desc,
+	}
+
+	if !p.Admin {
+		query.ID = p.UserID
+	}
+
+	if req.PageToken != "" {
+		query.After, err = decodePageToken(req.PageToken)
+		if err != nil || query.After <= 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "Invalid page token")
+		}
+	}
+
+	users, err := s.Store.List(ctx, query)
+	if err != nil {
+		return nil, storeError(err)
+	}
+
+	response := &pb.ListUsersResponse{}
+
+	if len(users) > pageSize {
+		users = users[:pageSize]
+		response.NextPageToken = encodePageToken(int64(users[pageSize-1].ID))
+	}
+
+	for _, user := range users {
+		response.Users = append(response.Users, userRecordToProto(user))
+	}
+
+	return response, nil
+}
+
+// WatchUsers streams the events of every user to admins and only those of
+// the caller to anyone else.
+func (s *userServiceServer) WatchUsers(req *pb.WatchUsersRequest, stream pb.UserService_WatchUsersServer) error {
+	p, ok := principalFromContext(stream.Context())
+	if !ok {
+		return status.Errorf(codes.Unauthenticated, "Unauthenticated")
+	}
+
+	send := func(event *pb.UserEvent) error {
+		if !p.Admin && event.GetUser().GetId() != p.UserID {
+			return nil
+		}
+		return stream.Send(event)
+	}
+
+	if s.events == nil {
+		return status.Errorf(codes.Unimplemented, "Watching users is not enabled")
+	}
+
+	if req.SinceSequence < 0 {
+		return status.Errorf(codes.InvalidArgument, "Invalid resume position")
+	}
+
+	backlog, events, err := s.events.subscribe(req.SinceSequence)
+	if err != nil {
+		return err
+	}
+	defer s.events.unsubscribe(events)
+
+	for _, event := range backlog {
+		if err := send(event); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-s.events.done:
+			return errShuttingDown
+		case event, ok := <-events:
+			if !ok {
+				return status.Errorf(codes.Aborted, "Watcher fell behind, resume from the last received sequence")
+			}
+
+			if err := send(event); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *userServiceServer) BatchCreateUsers(stream pb.UserService_BatchCreateUsersServer) error {
+	if s.Store == nil {
+		return status.Error(codes.Internal, "Database connection is nil")
+	}
+
+	response := &pb.BatchCreateUsersResponse{}
+
+	var batch []userRecord
+	var pending []*pb.BatchCreateUserResult
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+
+		s.createBatch(stream.Context(), batch, pending)
+
+		for i, result := range pending {
+			if result.Error != "" {
+				response.Failed++
+				continue
+			}
+
+			response.Created++
+			s.events.publish(pb.UserEvent_CREATED, userRecordToProto(batch[i]))
+		}
+
+		batch, pending = nil, nil
+	}
+
+	for index := int32(0); ; index++ {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		result := &pb.BatchCreateUserResult{Index: index}
+		response.Results = append(response.Results, result)
+
+		user := req.GetUser()
+
+		if err := validateUser(user); err != nil {
+			result.Error = status.Convert(err).Message()
+			response.Failed++
+			continue
+		}
+
+		record, token := s.newUserRecord(user)
+		result.Token = token
+		result.TokenExpiresAt = optionalTimestamp(record.Tokens[0].ExpiresAt)
+
+		batch = append(batch, record)
+		pending = append(pending, result)
+
+		if len(batch) == batchSize {
+			flush()
+		}
+	}
+
+	flush()
+
+	return stream.SendAndClose(response)
+}
+
+// createBatch inserts users in a single transaction. If the transaction fails
+// every user is retried on its own so one bad row only fails itself.
+func (s *userServiceServer) createBatch(ctx context.Context, users []userRecord, results []*pb.BatchCreateUserResult) {
+	records := make([]*userRecord, len(users))
+	for i := range users {
+		records[i] = &users[i]
+	}
+
+	if err := s.Store.Create(ctx, records...); err != nil {
+		for i := range users {
+			if s.ids == nil {
+				users[i].ID = 0
+			}
+			for j := range users[i].Tokens {
+				users[i].Tokens[j].ID = 0
+			}
+
+			if err := s.Store.Create(ctx, &users[i]); err != nil {
+				results[i].Error = status.Convert(storeError(err)).Message()
+			}
+		}
+	}
+
+	for i, user := range users {
+		if results[i].Error == "" {
+			results[i].Id = int64(user.ID)
+		} else {
+			results[i].Token, results[i].TokenExpiresAt = "", nil
+		}
+	}
+}
+
+func parseOrderBy(orderBy string) (string, bool, error) {
+	fields := strings.Fields(strings.ToLower(orderBy))
+
+	if len(fields) == 0 {
+		return "id", false, nil
+	}
+
+	column, ok := orderByColumns[fields[0]]
+	if !ok || len(fields) > 2 {
+		return "", false, fmt.Errorf("Invalid order_by %q", orderBy)
+	}
+
+	if len(fields) == 1 || fields[1] == "asc" {
+		return column, false, nil
+	}
+
+	if fields[1] == "desc" {
+		return column, true, nil
+	}
+
+	return "", false, fmt.Errorf("Invalid order_by %q", orderBy)
+}
+
+func encodePageToken(id int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(id, 10)))
+}
+
+func decodePageToken(token string) (int64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseInt(string(raw), 10, 64)
+}
+
 func main() {
-	dataSourceName := "user=postgres password=pgpswd dbname=UserDB host=localhost port=5433 sslmode=disable"
+	if err := config.Load(flag.CommandLine, os.Args[1:], "GREETER_SERVER"); err != nil {
+		logging.Fatal("Invalid configuration", "err", err)
+	}
+
+	logger, err := logging.New(os.Stderr, *logFormat, *logLevel)
+	if err != nil {
+		logging.Fatal("Invalid configuration", "err", err)
+	}
+	slog.SetDefault(logger)
+
+	if err := validateConfig(); err != nil {
+		logging.Fatal("Invalid configuration", "err", err)
+	}
+
+	if *printConfig {
+		if err := config.Print(os.Stdout, flag.CommandLine, "db-password", "token-pepper"); err != nil {
+			logging.Fatal("Failed to print configuration", "err", err)
+		}
+		return
+	}
+
+	if flag.Arg(0) == "migrate" {
+		store, err := openStore(*storeKind, dataSourceName())
+		if err != nil {
+			logging.Fatal("Error connecting to db", "store", *storeKind, "err", err)
+		}
+
+		if err := runMigrateCommand(context.Background(), store, flag.Args()[1:]); err != nil {
+			logging.Fatal("Migration failed", "err", err)
+		}
+		return
+	}
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		logging.Fatal("Failed to listen for gRPC", "addr", *addr, "err", err)
+	}
+
+	slog.Info("listening", "addr", *addr)
 
-	listener, err := net.Listen("tcp", addr)
+	admins, err := parseUserIDs(*adminUserIDs)
 	if err != nil {
-		log.Fatalf("Failed to listen for gRPC: %v", err)
+		logging.Fatal("Invalid admin user ids", "err", err)
 	}
 
-	log.Printf("listening on %s\n", addr)
+	server := &userServiceServer{
+		events:      newUserEvents(eventHistorySize),
+		idempotency: newIdempotencyCache(*idempotencyWindow),
+		tokenPepper: []byte(*tokenPepper),
+		tokenTTL:    *tokenTTL,
+		admins:      admins,
+		passwords: passwordPolicy{
+			MinLength:  *passwordMinLength,
+			MaxLength:  *passwordMaxLength,
+			MinClasses: *passwordMinClass,
+		},
+	}
+
+	if *storeKind == "memory" && *tokenPepper == "" {
+		server.tokenPepper = randomPepper()
+	}
+
+	switch *idStrategy {
+	case "serial":
+	case "snowflake":
+		server.ids, err = newSnowflake(*nodeID)
+		if err != nil {
+			logging.Fatal("Invalid node id", "err", err)
+		}
+	default:
+		logging.Fatal("Unknown id strategy", "id_strategy", *idStrategy)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *jwtKeyDir != "" {
+		keys, err := loadJWTKeys(*jwtKeyDir)
+		if err != nil {
+			logging.Fatal("Failed to load JWT signing keys", "err", err)
+		}
+
+		go keys.Watch(ctx, *jwtKeyReload)
+		server.jwt = newJWTIssuer(keys, *jwtIssuerName, *jwtTTL)
+	}
+
+	var opts []grpc.ServerOption
+
+	if *tlsCert != "" {
+		reloader, err := tlsconfig.NewReloader(tlsconfig.Files{Cert: *tlsCert, Key: *tlsKey, CA: *tlsClientCA})
+		if err != nil {
+			logging.Fatal("Failed to load TLS certificates", "err", err)
+		}
+
+		var allowed []string
+		if *tlsAllowedClients != "" {
+			allowed = strings.Split(*tlsAllowedClients, ",")
+		}
 
-	grpcServer := grpc.NewServer()
-	db := initialize(dataSourceName)
+		tlsConfig, err := reloader.ServerConfig(allowed)
+		if err != nil {
+			logging.Fatal("Invalid TLS configuration", "err", err)
+		}
 
-	pb.RegisterUserServiceServer(grpcServer, &userServiceServer{DB: db})
+		go reloader.Watch(ctx, *tlsReload)
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	} else {
+		slog.Warn("TLS is disabled, bearer tokens are sent in plaintext")
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+
+	shutdownTracing, err := tracing.Setup(ctx, "greeter_server", traceConfig())
+	if err != nil {
+		logging.Fatal("Failed to set up tracing", "err", err)
+	}
+
+	metrics := newRPCMetrics(registry)
+	opts = append(opts,
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.ChainUnaryInterceptor(loggingUnaryInterceptor, metrics.unaryInterceptor, server.authUnaryInterceptor),
+		grpc.ChainStreamInterceptor(loggingStreamInterceptor, metrics.streamInterceptor, server.authStreamInterceptor),
+	)
+
+	grpcServer := grpc.NewServer(opts...)
+	server.Store = initialize(*storeKind, dataSourceName(), server.tokenPepper)
+
+	if err := registerStoreMetrics(registry, server.Store); err != nil {
+		logging.Fatal("Failed to register db metrics", "err", err)
+	}
+
+	var metricsServer *http.Server
+	if *metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+		metricsServer = &http.Server{Addr: *metricsAddr, Handler: mux}
+
+		go func() {
+			slog.Info("serving metrics", "addr", *metricsAddr)
+			if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logging.Fatal("Failed to serve metrics", "err", err)
+			}
+		}()
+	}
+
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+	pb.RegisterUserServiceServer(grpcServer, server)
+
+	go watchHealth(ctx, healthServer, server.Store, *healthInterval, *healthInterval)
+
+	served := make(chan error, 1)
+	go func() {
+		served <- grpcServer.Serve(listener)
+	}()
+
+	select {
+	case err := <-served:
+		logging.Fatal("Failed to serve gRPC", "err", err)
+	case <-ctx.Done():
+		stop()
+	}
+
+	slog.Info("shutting down, draining in-flight requests")
+
+	healthServer.Shutdown()
+	server.events.shutdown()
+
+	if !gracefulStop(grpcServer, *shutdownTimeout) {
+		slog.Warn("in-flight requests did not finish in time, stopped forcefully", "timeout", *shutdownTimeout)
+	}
+
+	if metricsServer != nil {
+		metricsServer.Close()
+	}
+
+	if err := closeStore(server.Store); err != nil {
+		slog.Error("Error closing db", "err", err)
+	}
+
+	flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := shutdownTracing(flushCtx); err != nil {
+		slog.Error("Error flushing traces", "err", err)
+	}
+
+	slog.Info("server stopped")
+}
+
+// gracefulStop waits up to timeout for in-flight RPCs to finish and then
+// cuts off the rest. It reports whether the server drained in time.
+func gracefulStop(server *grpc.Server, timeout time.Duration) bool {
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-stopped:
+		return true
+	case <-timer.C:
+		server.Stop()
+		<-stopped
+		return false
+	}
+}
 
-	if err := grpcServer.Serve(listener); err != nil {
-		log.Fatalf("Failed to serve gRPC: %v", err)
+func closeStore(store UserStore) error {
+	if closer, ok := store.(io.Closer); ok {
+		return closer.Close()
 	}
+	return nil
 }
@@ -2,13 +2,20 @@ package main
 
 import (
 	"context"
+	"errors"
+	"io"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	pb "google.golang.org/grpc/examples/helloworld/helloworld"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
@@ -27,7 +34,7 @@ func TestCreateUser_InvalidUserData_UserNil_ReturnsError(t *testing.T) {
 	gormDB, err := gorm.Open(dialector, &gorm.Config{})
 	assert.Nil(t, err, "Failed to open GORM DB: %v", err)
 
-	server := &userServiceServer{DB: gormDB}
+	server := &userServiceServer{Store: newGormStore(gormDB)}
 
 	mock.ExpectBegin()
 
@@ -64,7 +71,7 @@ func TestCreateUser_InvalidUserData_FirstNameEmpty_ReturnsError(t *testing.T) {
 	gormDB, err := gorm.Open(dialector, &gorm.Config{})
 	assert.Nil(t, err, "Failed to open GORM DB: %v", err)
 
-	server := &userServiceServer{DB: gormDB}
+	server := &userServiceServer{Store: newGormStore(gormDB)}
 
 	mock.ExpectBegin()
 
@@ -108,7 +115,7 @@ func TestCreateUser_InvalidUserData_LastNameEmpty_ReturnsError(t *testing.T) {
 
 	assert.Nil(t, err, "Failed to open GORM DB: %v", err)
 
-	server := &userServiceServer{DB: gormDB}
+	server := &userServiceServer{Store: newGormStore(gormDB)}
 
 	mock.ExpectBegin()
 
@@ -152,7 +159,7 @@ func TestCreateUser_InvalidUserData_NegativeAge_ReturnsError(t *testing.T) {
 
 	assert.Nil(t, err, "Failed to open GORM DB: %v", err)
 
-	server := &userServiceServer{DB: gormDB}
+	server := &userServiceServer{Store: newGormStore(gormDB)}
 
 	mock.ExpectBegin()
 
@@ -193,7 +200,7 @@ func TestCreateUser_InvalidUserData_ZeroAge_ReturnsError(t *testing.T) {
 	gormDB, err := gorm.Open(dialector, &gorm.Config{})
 	assert.Nil(t, err, "Failed to open GORM DB: %v", err)
 
-	server := &userServiceServer{DB: gormDB}
+	server := &userServiceServer{Store: newGormStore(gormDB)}
 
 	mock.ExpectBegin()
 
@@ -236,12 +243,13 @@ func TestCreateUser_success(t *testing.T) {
 	gormDB, err := gorm.Open(dialector, &gorm.Config{})
 	assert.Nil(t, err, "Failed to open GORM DB: %v", err)
 
-	server := &userServiceServer{DB: gormDB}
+	server := &userServiceServer{Store: newGormStore(gormDB)}
 
 	mock.ExpectBegin()
 
 	rows := sqlmock.NewRows([]string{"id", "first_name", "last_name", "age"}).AddRow("1", "Cool", "Kid", 10)
 	mock.ExpectQuery("INSERT").WillReturnRows(rows)
+	mock.ExpectQuery(`INSERT INTO "user_tokens"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
 
 	mock.ExpectCommit()
 
@@ -263,7 +271,6 @@ func TestCreateUser_success(t *testing.T) {
 	assert.Equal(t, "Cool", resp.User.FirstName)
 	assert.Equal(t, "Kid", resp.User.LastName)
 	assert.Equal(t, int32(10), resp.User.Age)
-	assert.NotNil(t, resp.User.Token)
 }
 
 func TestCreateUser_ZeroRowsAffected_throwsError(t *testing.T) {
@@ -279,7 +286,7 @@ func TestCreateUser_ZeroRowsAffected_throwsError(t *testing.T) {
 	gormDB, err := gorm.Open(dialector, &gorm.Config{})
 	assert.Nil(t, err, "Failed to open GORM DB: %v", err)
 
-	server := &userServiceServer{DB: gormDB}
+	server := &userServiceServer{Store: newGormStore(gormDB)}
 
 	mock.ExpectBegin()
 
@@ -301,7 +308,90 @@ func TestCreateUser_ZeroRowsAffected_throwsError(t *testing.T) {
 
 	assert.Error(t, err)
 	assert.Nil(t, resp)
-	assert.EqualError(t, err, "cannot create user successfully")
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.EqualError(t, err, "rpc error: code = Internal desc = Cannot create user successfully")
+}
+
+func TestCreateUser_ClientSuppliedID_IsIgnored(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+
+	assert.Nil(t, err, "Failed to create mock DB: %v", err)
+	defer mockDB.Close()
+
+	dialector := postgres.New(postgres.Config{
+		Conn:       mockDB,
+		DriverName: "postgres",
+	})
+
+	gormDB, err := gorm.Open(dialector, &gorm.Config{})
+	assert.Nil(t, err, "Failed to open GORM DB: %v", err)
+
+	server := &userServiceServer{Store: newGormStore(gormDB)}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+	mock.ExpectQuery(`INSERT INTO "user_tokens"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
+	req := &pb.CreateUserRequest{
+		User: &pb.User{
+			Id:        99,
+			FirstName: "Cool",
+			LastName:  "Kid",
+			Age:       10,
+		},
+	}
+
+	resp, err := server.CreateUser(context.Background(), req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), resp.User.Id)
+	assert.Equal(t, int64(1), resp.User.Version)
+	assert.NotNil(t, resp.User.CreatedAt)
+	assert.False(t, resp.User.CreatedAt.AsTime().IsZero())
+	assert.NotEmpty(t, resp.Token)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateUser_SnowflakeStrategy_AssignsID(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+
+	assert.Nil(t, err, "Failed to create mock DB: %v", err)
+	defer mockDB.Close()
+
+	dialector := postgres.New(postgres.Config{
+		Conn:       mockDB,
+		DriverName: "postgres",
+	})
+
+	gormDB, err := gorm.Open(dialector, &gorm.Config{})
+	assert.Nil(t, err, "Failed to open GORM DB: %v", err)
+
+	ids, err := newSnowflake(3)
+	assert.NoError(t, err)
+
+	server := &userServiceServer{Store: newGormStore(gormDB), ids: ids}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT").WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(1))
+	mock.ExpectQuery(`INSERT INTO "user_tokens"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
+	req := &pb.CreateUserRequest{
+		User: &pb.User{
+			Id:        99,
+			FirstName: "Cool",
+			LastName:  "Kid",
+			Age:       10,
+		},
+	}
+
+	resp, err := server.CreateUser(context.Background(), req)
+
+	assert.NoError(t, err)
+	assert.Greater(t, resp.User.Id, int64(99))
+	assert.Equal(t, int64(3), resp.User.Id>>snowflakeSequenceBits&snowflakeMaxNode)
+	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
 func TestGetUser_GotUserIDZeroFromDB_UserNotFound_ReturnsError(t *testing.T) {
@@ -318,21 +408,21 @@ func TestGetUser_GotUserIDZeroFromDB_UserNotFound_ReturnsError(t *testing.T) {
 	gormDB, err := gorm.Open(dialector, &gorm.Config{})
 	assert.Nil(t, err, "Failed to open GORM DB: %v", err)
 
-	server := &userServiceServer{DB: gormDB}
+	server := &userServiceServer{Store: newGormStore(gormDB)}
 
-	rows := sqlmock.NewRows([]string{"id", "first_name", "last_name", "age", "token"}).AddRow(0, "Cool", "Kid", 12, "valid_token")
+	rows := sqlmock.NewRows([]string{"id", "first_name", "last_name", "age"}).AddRow(0, "Cool", "Kid", 12)
 	mock.ExpectQuery("SELECT").WillReturnRows(rows)
 
 	req := &pb.GetUserRequest{
-		Id:    1,
-		Token: "valid_token",
+		Id: 1,
 	}
 
-	resp, err := server.GetUser(context.Background(), req)
+	resp, err := server.GetUser(authenticated(1), req)
 
 	assert.Error(t, err)
 	assert.Nil(t, resp)
-	assert.EqualError(t, err, "user not found")
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.EqualError(t, err, "rpc error: code = NotFound desc = User not found")
 }
 
 func TestGetUser_InvalidToken_NotAuthenticated_ReturnsError(t *testing.T) {
@@ -349,9 +439,9 @@ func TestGetUser_InvalidToken_NotAuthenticated_ReturnsError(t *testing.T) {
 	gormDB, err := gorm.Open(dialector, &gorm.Config{})
 	assert.Nil(t, err, "Failed to open GORM DB: %v", err)
 
-	server := &userServiceServer{DB: gormDB}
+	server := &userServiceServer{Store: newGormStore(gormDB)}
 
-	rows := sqlmock.NewRows([]string{"id", "first_name", "last_name", "age", "token"}).AddRow(1, "Cool", "Kid", 12, "valid_token")
+	rows := sqlmock.NewRows([]string{"id", "user_id", "token_hash"}).AddRow(1, 1, hashToken(nil, "valid_token"))
 	mock.ExpectQuery("SELECT").WillReturnRows(rows)
 
 	req := &pb.GetUserRequest{
@@ -359,12 +449,28 @@ func TestGetUser_InvalidToken_NotAuthenticated_ReturnsError(t *testing.T) {
 		Token: "invalid_token",
 	}
 
-	resp, err := server.GetUser(context.Background(), req)
+	resp, err := callAuthenticated(server, "GetUser", req, server.GetUser)
 
 	assert.Error(t, err)
 	assert.Nil(t, resp)
 }
 
+func TestGetUser_RevokedToken_NotAuthenticated_ReturnsError(t *testing.T) {
+	server := &userServiceServer{Store: newMemoryStore(), events: newUserEvents(10)}
+	client := newAuthTestClient(t, server)
+
+	created, err := client.CreateUser(context.Background(), &pb.CreateUserRequest{User: &pb.User{FirstName: "Cool", LastName: "Kid", Age: 12}})
+	assert.Nil(t, err)
+
+	_, err = client.RevokeToken(withBearer(created.Token), &pb.RevokeTokenRequest{Id: created.User.Id})
+	assert.Nil(t, err)
+
+	resp, err := client.GetUser(withBearer(created.Token), &pb.GetUserRequest{Id: created.User.Id})
+
+	assert.Nil(t, resp)
+	assert.EqualError(t, err, "rpc error: code = Unauthenticated desc = Unauthenticated")
+}
+
 func TestGetUser_success(t *testing.T) {
 	mockDB, mock, err := sqlmock.New()
 
@@ -379,17 +485,16 @@ func TestGetUser_success(t *testing.T) {
 	gormDB, err := gorm.Open(dialector, &gorm.Config{})
 	assert.Nil(t, err, "Failed to open GORM DB: %v", err)
 
-	server := &userServiceServer{DB: gormDB}
+	server := &userServiceServer{Store: newGormStore(gormDB)}
 
-	rows := sqlmock.NewRows([]string{"id", "first_name", "last_name", "age", "token"}).AddRow(1, "Cool", "Kid", 12, "valid_token")
+	rows := sqlmock.NewRows([]string{"id", "first_name", "last_name", "age"}).AddRow(1, "Cool", "Kid", 12)
 	mock.ExpectQuery("SELECT").WillReturnRows(rows)
 
 	req := &pb.GetUserRequest{
-		Id:    1,
-		Token: "valid_token",
+		Id: 1,
 	}
 
-	resp, err := server.GetUser(context.Background(), req)
+	resp, err := server.GetUser(authenticated(1), req)
 
 	assert.NoError(t, err)
 	assert.NotNil(t, resp)
@@ -398,7 +503,6 @@ func TestGetUser_success(t *testing.T) {
 	assert.Equal(t, "Cool", resp.User.FirstName)
 	assert.Equal(t, "Kid", resp.User.LastName)
 	assert.Equal(t, int32(12), resp.User.Age)
-	assert.Equal(t, "valid_token", resp.User.Token)
 }
 
 func TestUpdateUser_InvalidUserData_UserNil_ReturnsError(t *testing.T) {
@@ -415,7 +519,7 @@ func TestUpdateUser_InvalidUserData_UserNil_ReturnsError(t *testing.T) {
 	gormDB, err := gorm.Open(dialector, &gorm.Config{})
 	assert.Nil(t, err, "Failed to open GORM DB: %v", err)
 
-	server := &userServiceServer{DB: gormDB}
+	server := &userServiceServer{Store: newGormStore(gormDB)}
 
 	mock.ExpectBegin()
 
@@ -452,7 +556,7 @@ func TestUpdateUser_InvalidUserData_FirstNameEmpty_ReturnsError(t *testing.T) {
 	gormDB, err := gorm.Open(dialector, &gorm.Config{})
 	assert.Nil(t, err, "Failed to open GORM DB: %v", err)
 
-	server := &userServiceServer{DB: gormDB}
+	server := &userServiceServer{Store: newGormStore(gormDB)}
 
 	mock.ExpectBegin()
 
@@ -496,7 +600,7 @@ func TestUpdateUser_InvalidUserData_LastNameEmpty_ReturnsError(t *testing.T) {
 
 	assert.Nil(t, err, "Failed to open GORM DB: %v", err)
 
-	server := &userServiceServer{DB: gormDB}
+	server := &userServiceServer{Store: newGormStore(gormDB)}
 
 	mock.ExpectBegin()
 
@@ -540,7 +644,7 @@ func TestUpdateUser_InvalidUserData_NegativeAge_ReturnsError(t *testing.T) {
 
 	assert.Nil(t, err, "Failed to open GORM DB: %v", err)
 
-	server := &userServiceServer{DB: gormDB}
+	server := &userServiceServer{Store: newGormStore(gormDB)}
 
 	mock.ExpectBegin()
 
@@ -582,9 +686,9 @@ func TestUpdateUser_InvalidToken_NotAuthenticated_ReturnsError(t *testing.T) {
 	gormDB, err := gorm.Open(dialector, &gorm.Config{})
 	assert.Nil(t, err, "Failed to open GORM DB: %v", err)
 
-	server := &userServiceServer{DB: gormDB}
+	server := &userServiceServer{Store: newGormStore(gormDB)}
 
-	rows := sqlmock.NewRows([]string{"id", "first_name", "last_name", "age", "token"}).AddRow(1, "Cool", "Kid", 12, "valid_token")
+	rows := sqlmock.NewRows([]string{"id", "user_id", "token_hash"}).AddRow(1, 1, hashToken(nil, "valid_token"))
 	mock.ExpectQuery("SELECT").WillReturnRows(rows)
 
 	req := &pb.UpdateUserRequest{
@@ -594,16 +698,47 @@ func TestUpdateUser_InvalidToken_NotAuthenticated_ReturnsError(t *testing.T) {
 			FirstName: "UpdatedFirstName",
 			LastName:  "UpdatedLastName",
 			Age:       10,
+			Version:   1,
 		},
 		Token: "invalidToken",
 	}
 
-	resp, err := server.UpdateUser(context.Background(), req)
+	resp, err := callAuthenticated(server, "UpdateUser", req, server.UpdateUser)
 
 	assert.Error(t, err)
 	assert.Nil(t, resp)
 }
 
+func TestUpdateUser_ExpiredToken_NotAuthenticated_ReturnsError(t *testing.T) {
+	server := &userServiceServer{Store: newMemoryStore(), events: newUserEvents(10), tokenTTL: time.Hour}
+	client := newAuthTestClient(t, server)
+
+	created, err := client.CreateUser(context.Background(), &pb.CreateUserRequest{User: &pb.User{FirstName: "Cool", LastName: "Kid", Age: 12}})
+	assert.Nil(t, err)
+
+	expired, record := server.newUserToken(uint(created.User.Id), time.Now().Add(-2*time.Hour))
+	assert.Nil(t, server.Store.AddToken(context.Background(), &record))
+
+	req := &pb.UpdateUserRequest{
+		Id: created.User.Id,
+		User: &pb.User{
+			FirstName: "UpdatedFirstName",
+			LastName:  "UpdatedLastName",
+			Age:       10,
+			Version:   1,
+		},
+	}
+
+	resp, err := client.UpdateUser(withBearer(expired), req)
+
+	assert.Nil(t, resp)
+	assert.EqualError(t, err, "rpc error: code = Unauthenticated desc = Unauthenticated")
+
+	user, err := server.Store.Get(context.Background(), created.User.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, "Cool", user.FirstName, "Expected an expired token not to update the user")
+}
+
 func TestUpdateUser_success(t *testing.T) {
 	mockDB, mock, err := sqlmock.New()
 
@@ -618,9 +753,7 @@ func TestUpdateUser_success(t *testing.T) {
 	gormDB, err := gorm.Open(dialector, &gorm.Config{})
 	assert.Nil(t, err, "Failed to open GORM DB: %v", err)
 
-	server := &userServiceServer{DB: gormDB}
-
-	mock.ExpectBegin()
+	server := &userServiceServer{Store: newGormStore(gormDB)}
 
 	req := &pb.UpdateUserRequest{
 		Id: 1,
@@ -629,25 +762,676 @@ func TestUpdateUser_success(t *testing.T) {
 			FirstName: "UpdatedFirstName",
 			LastName:  "UpdatedLastName",
 			Age:       10,
+			Version:   3,
 		},
-		Token: "validToken",
 	}
 
-	rows := sqlmock.NewRows([]string{"id", "first_name", "last_name", "age", "token"}).AddRow("1", "FirstName", "LastName", 20, "validToken")
-	newRow := sqlmock.NewRows([]string{"id", "first_name", "last_name", "age", "token"}).AddRow("1", "UpdatedFirstName", "UpdatedLastName", 10, "validToken")
+	rows := sqlmock.NewRows([]string{"id", "first_name", "last_name", "age", "version"}).AddRow("1", "FirstName", "LastName", 20, 3)
 
-	mock.ExpectQuery("SELECT").WithArgs(req.Id).WillReturnRows(rows)
-	mock.ExpectQuery("UPDATE").WillReturnRows(newRow)
+	mock.ExpectQuery("SELECT").WithArgs(req.Id, 1).WillReturnRows(rows)
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "users" SET .*"version"=version \+ 1.* WHERE \(id = \$\d AND version = \$\d\)`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
 	mock.ExpectCommit()
 
-	resp, err := server.UpdateUser(context.Background(), req)
+	resp, err := server.UpdateUser(authenticated(1), req)
 
-	assert.NoError(t, err, "Unexpected error in CreatUser")
+	assert.NoError(t, err, "Unexpected error in UpdateUser")
 	assert.NotNil(t, resp, "Expected non-nil response")
-	assert.Equal(t, "Created user successfully", resp.Message, "Unexpected response message")
+	assert.Equal(t, "User successfully updated", resp.Message, "Unexpected response message")
 	assert.Equal(t, int64(1), resp.User.Id)
-	assert.Equal(t, "Cool", resp.User.FirstName)
-	assert.Equal(t, "Kid", resp.User.LastName)
+	assert.Equal(t, "UpdatedFirstName", resp.User.FirstName)
+	assert.Equal(t, "UpdatedLastName", resp.User.LastName)
 	assert.Equal(t, int32(10), resp.User.Age)
-	assert.NotNil(t, resp.User.Token)
+	assert.Equal(t, int64(4), resp.User.Version)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateUser_MissingVersion_ReturnsFailedPrecondition(t *testing.T) {
+	mockDB, _, err := sqlmock.New()
+
+	assert.Nil(t, err, "Failed to create mock DB: %v", err)
+	defer mockDB.Close()
+
+	dialector := postgres.New(postgres.Config{
+		Conn:       mockDB,
+		DriverName: "postgres",
+	})
+
+	gormDB, err := gorm.Open(dialector, &gorm.Config{})
+	assert.Nil(t, err, "Failed to open GORM DB: %v", err)
+
+	server := &userServiceServer{Store: newGormStore(gormDB)}
+
+	req := &pb.UpdateUserRequest{
+		Id: 1,
+		User: &pb.User{
+			FirstName: "UpdatedFirstName",
+			LastName:  "UpdatedLastName",
+			Age:       10,
+		},
+	}
+
+	resp, err := server.UpdateUser(authenticated(1), req)
+
+	assert.Error(t, err)
+	assert.Nil(t, resp)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+func TestUpdateUser_StaleVersion_ReturnsAborted(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+
+	assert.Nil(t, err, "Failed to create mock DB: %v", err)
+	defer mockDB.Close()
+
+	dialector := postgres.New(postgres.Config{
+		Conn:       mockDB,
+		DriverName: "postgres",
+	})
+
+	gormDB, err := gorm.Open(dialector, &gorm.Config{})
+	assert.Nil(t, err, "Failed to open GORM DB: %v", err)
+
+	server := &userServiceServer{Store: newGormStore(gormDB)}
+
+	rows := sqlmock.NewRows([]string{"id", "first_name", "last_name", "age", "version"}).AddRow("1", "FirstName", "LastName", 20, 4)
+	mock.ExpectQuery("SELECT").WillReturnRows(rows)
+
+	req := &pb.UpdateUserRequest{
+		Id: 1,
+		User: &pb.User{
+			FirstName: "UpdatedFirstName",
+			LastName:  "UpdatedLastName",
+			Age:       10,
+			Version:   3,
+		},
+	}
+
+	resp, err := server.UpdateUser(authenticated(1), req)
+
+	assert.Error(t, err)
+	assert.Nil(t, resp)
+	assert.Equal(t, codes.Aborted, status.Code(err))
+	require.Len(t, status.Convert(err).Details(), 1)
+	assert.Equal(t, versionConflictReason, status.Convert(err).Details()[0].(*errdetails.ErrorInfo).Reason)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateUser_ConcurrentWrite_ReturnsAborted(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+
+	assert.Nil(t, err, "Failed to create mock DB: %v", err)
+	defer mockDB.Close()
+
+	dialector := postgres.New(postgres.Config{
+		Conn:       mockDB,
+		DriverName: "postgres",
+	})
+
+	gormDB, err := gorm.Open(dialector, &gorm.Config{})
+	assert.Nil(t, err, "Failed to open GORM DB: %v", err)
+
+	server := &userServiceServer{Store: newGormStore(gormDB)}
+
+	rows := sqlmock.NewRows([]string{"id", "first_name", "last_name", "age", "version"}).AddRow("1", "FirstName", "LastName", 20, 3)
+	mock.ExpectQuery("SELECT").WillReturnRows(rows)
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	req := &pb.UpdateUserRequest{
+		Id: 1,
+		User: &pb.User{
+			FirstName: "UpdatedFirstName",
+			LastName:  "UpdatedLastName",
+			Age:       10,
+			Version:   3,
+		},
+	}
+
+	resp, err := server.UpdateUser(authenticated(1), req)
+
+	assert.Error(t, err)
+	assert.Nil(t, resp)
+	assert.Equal(t, codes.Aborted, status.Code(err))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeleteUser_UserNotFound_ReturnsError(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+
+	assert.Nil(t, err, "Failed to create mock DB: %v", err)
+	defer mockDB.Close()
+
+	dialector := postgres.New(postgres.Config{
+		Conn:       mockDB,
+		DriverName: "postgres",
+	})
+
+	gormDB, err := gorm.Open(dialector, &gorm.Config{})
+	assert.Nil(t, err, "Failed to open GORM DB: %v", err)
+
+	server := &userServiceServer{Store: newGormStore(gormDB)}
+
+	rows := sqlmock.NewRows([]string{"id", "first_name", "last_name", "age"})
+	mock.ExpectQuery("SELECT").WillReturnRows(rows)
+
+	req := &pb.DeleteUserRequest{
+		Id: 1,
+	}
+
+	resp, err := server.DeleteUser(authenticated(1), req)
+
+	assert.Error(t, err)
+	assert.Nil(t, resp)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.EqualError(t, err, "rpc error: code = NotFound desc = User not found")
+}
+
+func TestDeleteUser_InvalidToken_NotAuthenticated_ReturnsError(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+
+	assert.Nil(t, err, "Failed to create mock DB: %v", err)
+	defer mockDB.Close()
+
+	dialector := postgres.New(postgres.Config{
+		Conn:       mockDB,
+		DriverName: "postgres",
+	})
+
+	gormDB, err := gorm.Open(dialector, &gorm.Config{})
+	assert.Nil(t, err, "Failed to open GORM DB: %v", err)
+
+	server := &userServiceServer{Store: newGormStore(gormDB)}
+
+	rows := sqlmock.NewRows([]string{"id", "user_id", "token_hash"}).AddRow(1, 1, hashToken(nil, "valid_token"))
+	mock.ExpectQuery("SELECT").WillReturnRows(rows)
+
+	req := &pb.DeleteUserRequest{
+		Id:    1,
+		Token: "invalid_token",
+	}
+
+	resp, err := callAuthenticated(server, "DeleteUser", req, server.DeleteUser)
+
+	statusErr, ok := status.FromError(err)
+
+	assert.Error(t, err)
+	assert.Nil(t, resp)
+	assert.True(t, ok, "Expected gRPC status error")
+	assert.Equal(t, codes.Unauthenticated, statusErr.Code())
+}
+
+func TestDeleteUser_RevokedToken_NotAuthenticated_ReturnsError(t *testing.T) {
+	server := &userServiceServer{Store: newMemoryStore(), events: newUserEvents(10)}
+	client := newAuthTestClient(t, server)
+
+	created, err := client.CreateUser(context.Background(), &pb.CreateUserRequest{User: &pb.User{FirstName: "Cool", LastName: "Kid", Age: 12}})
+	assert.Nil(t, err)
+
+	_, err = client.RevokeToken(withBearer(created.Token), &pb.RevokeTokenRequest{Id: created.User.Id, TokenToRevoke: created.Token})
+	assert.Nil(t, err)
+
+	resp, err := client.DeleteUser(withBearer(created.Token), &pb.DeleteUserRequest{Id: created.User.Id})
+
+	statusErr, ok := status.FromError(err)
+
+	assert.Error(t, err)
+	assert.Nil(t, resp)
+	assert.True(t, ok, "Expected gRPC status error")
+	assert.Equal(t, codes.Unauthenticated, statusErr.Code())
+
+	_, err = server.Store.Get(context.Background(), created.User.Id)
+	assert.Nil(t, err, "Expected a revoked token not to delete the user")
+}
+
+func TestDeleteUser_success_SoftDeletes(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+
+	assert.Nil(t, err, "Failed to create mock DB: %v", err)
+	defer mockDB.Close()
+
+	dialector := postgres.New(postgres.Config{
+		Conn:       mockDB,
+		DriverName: "postgres",
+	})
+
+	gormDB, err := gorm.Open(dialector, &gorm.Config{})
+	assert.Nil(t, err, "Failed to open GORM DB: %v", err)
+
+	server := &userServiceServer{Store: newGormStore(gormDB)}
+
+	rows := sqlmock.NewRows([]string{"id", "first_name", "last_name", "age"}).AddRow(1, "Cool", "Kid", 12)
+	mock.ExpectQuery("SELECT").WillReturnRows(rows)
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "users" SET "deleted_at"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	req := &pb.DeleteUserRequest{
+		Id: 1,
+	}
+
+	resp, err := server.DeleteUser(authenticated(1), req)
+
+	assert.NoError(t, err)
+	assert.NotNil(t, resp)
+	assert.Equal(t, "User successfully deleted", resp.Message)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestListUsers_InvalidOrderBy_ReturnsError(t *testing.T) {
+	mockDB, _, err := sqlmock.New()
+
+	assert.Nil(t, err, "Failed to create mock DB: %v", err)
+	defer mockDB.Close()
+
+	dialector := postgres.New(postgres.Config{
+		Conn:       mockDB,
+		DriverName: "postgres",
+	})
+
+	gormDB, err := gorm.Open(dialector, &gorm.Config{})
+	assert.Nil(t, err, "Failed to open GORM DB: %v", err)
+
+	server := &userServiceServer{Store: newGormStore(gormDB)}
+
+	resp, err := server.ListUsers(authenticatedAdmin(1), &pb.ListUsersRequest{OrderBy: "token"})
+
+	statusErr, ok := status.FromError(err)
+
+	assert.Error(t, err)
+	assert.Nil(t, resp)
+	assert.True(t, ok, "Expected gRPC status error")
+	assert.Equal(t, codes.InvalidArgument, statusErr.Code())
+}
+
+func TestListUsers_InvalidAgeRange_ReturnsError(t *testing.T) {
+	mockDB, _, err := sqlmock.New()
+
+	assert.Nil(t, err, "Failed to create mock DB: %v", err)
+	defer mockDB.Close()
+
+	dialector := postgres.New(postgres.Config{
+		Conn:       mockDB,
+		DriverName: "postgres",
+	})
+
+	gormDB, err := gorm.Open(dialector, &gorm.Config{})
+	assert.Nil(t, err, "Failed to open GORM DB: %v", err)
+
+	server := &userServiceServer{Store: newGormStore(gormDB)}
+
+	resp, err := server.ListUsers(authenticatedAdmin(1), &pb.ListUsersRequest{MinAge: 30, MaxAge: 20})
+
+	statusErr, ok := status.FromError(err)
+
+	assert.Error(t, err)
+	assert.Nil(t, resp)
+	assert.True(t, ok, "Expected gRPC status error")
+	assert.Equal(t, codes.InvalidArgument, statusErr.Code())
+}
+
+func TestListUsers_MorePages_ReturnsNextPageToken(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+
+	assert.Nil(t, err, "Failed to create mock DB: %v", err)
+	defer mockDB.Close()
+
+	dialector := postgres.New(postgres.Config{
+		Conn:       mockDB,
+		DriverName: "postgres",
+	})
+
+	gormDB, err := gorm.Open(dialector, &gorm.Config{})
+	assert.Nil(t, err, "Failed to open GORM DB: %v", err)
+
+	server := &userServiceServer{Store: newGormStore(gormDB)}
+
+	rows := sqlmock.NewRows([]string{"id", "first_name", "last_name", "age"}).
+		AddRow(3, "Cool", "Kid", 12).
+		AddRow(4, "Other", "Kid", 14).
+		AddRow(5, "Third", "Kid", 16)
+	mock.ExpectQuery(`SELECT \* FROM "users" WHERE last_name = \$1 AND age >= \$2 AND id > \$3 AND "users"."deleted_at" IS NULL ORDER BY id ASC LIMIT \$4`).
+		WithArgs("Kid", 10, 2, 3).
+		WillReturnRows(rows)
+
+	req := &pb.ListUsersRequest{
+		PageSize:  2,
+		PageToken: encodePageToken(2),
+		LastName:  "Kid",
+		MinAge:    10,
+	}
+
+	resp, err := server.ListUsers(authenticatedAdmin(1), req)
+
+	assert.NoError(t, err)
+	assert.NotNil(t, resp)
+	assert.Len(t, resp.Users, 2)
+	assert.Equal(t, int64(3), resp.Users[0].Id)
+	assert.Equal(t, int64(4), resp.Users[1].Id)
+	assert.Equal(t, encodePageToken(4), resp.NextPageToken)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestListUsers_OrderByAgeDesc_UsesKeysetOnCursorRow(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+
+	assert.Nil(t, err, "Failed to create mock DB: %v", err)
+	defer mockDB.Close()
+
+	dialector := postgres.New(postgres.Config{
+		Conn:       mockDB,
+		DriverName: "postgres",
+	})
+
+	gormDB, err := gorm.Open(dialector, &gorm.Config{})
+	assert.Nil(t, err, "Failed to open GORM DB: %v", err)
+
+	server := &userServiceServer{Store: newGormStore(gormDB)}
+
+	rows := sqlmock.NewRows([]string{"id", "first_name", "last_name", "age"}).
+		AddRow(7, "Cool", "Kid", 12)
+	mock.ExpectQuery(`WHERE \(age, id\) < \(SELECT age, id FROM users WHERE id = \$1\) AND "users"."deleted_at" IS NULL ORDER BY age DESC,id DESC`).
+		WithArgs(9, 51).
+		WillReturnRows(rows)
+
+	req := &pb.ListUsersRequest{
+		PageToken: encodePageToken(9),
+		OrderBy:   "age desc",
+	}
+
+	resp, err := server.ListUsers(authenticatedAdmin(1), req)
+
+	assert.NoError(t, err)
+	assert.Len(t, resp.Users, 1)
+	assert.Empty(t, resp.NextPageToken)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+type batchCreateUsersStream struct {
+	grpc.ServerStream
+	requests []*pb.BatchCreateUsersRequest
+	response *pb.BatchCreateUsersResponse
+}
+
+func (s *batchCreateUsersStream) Context() context.Context {
+	return context.Background()
+}
+
+func (s *batchCreateUsersStream) Recv() (*pb.BatchCreateUsersRequest, error) {
+	if len(s.requests) == 0 {
+		return nil, io.EOF
+	}
+
+	req := s.requests[0]
+	s.requests = s.requests[1:]
+
+	return req, nil
+}
+
+func (s *batchCreateUsersStream) SendAndClose(resp *pb.BatchCreateUsersResponse) error {
+	s.response = resp
+	return nil
+}
+
+func TestBatchCreateUsers_InvalidUser_ReportedPerItem(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+
+	assert.Nil(t, err, "Failed to create mock DB: %v", err)
+	defer mockDB.Close()
+
+	dialector := postgres.New(postgres.Config{
+		Conn:       mockDB,
+		DriverName: "postgres",
+	})
+
+	gormDB, err := gorm.Open(dialector, &gorm.Config{})
+	assert.Nil(t, err, "Failed to open GORM DB: %v", err)
+
+	server := &userServiceServer{Store: newGormStore(gormDB)}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+	mock.ExpectQuery(`INSERT INTO "user_tokens"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+	mock.ExpectCommit()
+
+	stream := &batchCreateUsersStream{
+		requests: []*pb.BatchCreateUsersRequest{
+			{User: &pb.User{FirstName: "Cool", LastName: "Kid", Age: 10}},
+			{User: &pb.User{FirstName: "", LastName: "Kid", Age: 10}},
+			{User: &pb.User{FirstName: "Other", LastName: "Kid", Age: 12}},
+		},
+	}
+
+	err = server.BatchCreateUsers(stream)
+
+	assert.NoError(t, err)
+	assert.NotNil(t, stream.response)
+	assert.Equal(t, int32(2), stream.response.Created)
+	assert.Equal(t, int32(1), stream.response.Failed)
+	assert.Len(t, stream.response.Results, 3)
+
+	assert.Equal(t, int64(1), stream.response.Results[0].Id)
+	assert.NotEmpty(t, stream.response.Results[0].Token)
+	assert.Equal(t, "Invalid user data", stream.response.Results[1].Error)
+	assert.Equal(t, int32(1), stream.response.Results[1].Index)
+	assert.Zero(t, stream.response.Results[1].Id)
+	assert.Equal(t, int64(2), stream.response.Results[2].Id)
+	assert.NotEqual(t, stream.response.Results[0].Token, stream.response.Results[2].Token)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestBatchCreateUsers_BatchFails_RetriesEachUser(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+
+	assert.Nil(t, err, "Failed to create mock DB: %v", err)
+	defer mockDB.Close()
+
+	dialector := postgres.New(postgres.Config{
+		Conn:       mockDB,
+		DriverName: "postgres",
+	})
+
+	gormDB, err := gorm.Open(dialector, &gorm.Config{})
+	assert.Nil(t, err, "Failed to open GORM DB: %v", err)
+
+	server := &userServiceServer{Store: newGormStore(gormDB)}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT").WillReturnError(errors.New("constraint violation"))
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectQuery(`INSERT INTO "user_tokens"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT").WillReturnError(errors.New("constraint violation"))
+	mock.ExpectRollback()
+
+	stream := &batchCreateUsersStream{
+		requests: []*pb.BatchCreateUsersRequest{
+			{User: &pb.User{FirstName: "Cool", LastName: "Kid", Age: 10}},
+			{User: &pb.User{FirstName: "Other", LastName: "Kid", Age: 12}},
+		},
+	}
+
+	err = server.BatchCreateUsers(stream)
+
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), stream.response.Created)
+	assert.Equal(t, int32(1), stream.response.Failed)
+	assert.Equal(t, int64(7), stream.response.Results[0].Id)
+	assert.Empty(t, stream.response.Results[0].Error)
+	assert.Zero(t, stream.response.Results[1].Id)
+	assert.Empty(t, stream.response.Results[1].Token)
+	assert.Equal(t, "Internal error", stream.response.Results[1].Error, "Expected driver errors not to reach the caller")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPatchUser_UnknownMaskPath_ReturnsError(t *testing.T) {
+	mockDB, _, err := sqlmock.New()
+
+	assert.Nil(t, err, "Failed to create mock DB: %v", err)
+	defer mockDB.Close()
+
+	dialector := postgres.New(postgres.Config{
+		Conn:       mockDB,
+		DriverName: "postgres",
+	})
+
+	gormDB, err := gorm.Open(dialector, &gorm.Config{})
+	assert.Nil(t, err, "Failed to open GORM DB: %v", err)
+
+	server := &userServiceServer{Store: newGormStore(gormDB)}
+
+	req := &pb.PatchUserRequest{
+		Id:         1,
+		User:       &pb.User{},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"token"}},
+	}
+
+	resp, err := server.PatchUser(authenticated(1), req)
+
+	statusErr, ok := status.FromError(err)
+
+	assert.Error(t, err)
+	assert.Nil(t, resp)
+	assert.True(t, ok, "Expected gRPC status error")
+	assert.Equal(t, codes.InvalidArgument, statusErr.Code())
+}
+
+func TestPatchUser_InvalidMaskedField_ReturnsError(t *testing.T) {
+	mockDB, _, err := sqlmock.New()
+
+	assert.Nil(t, err, "Failed to create mock DB: %v", err)
+	defer mockDB.Close()
+
+	dialector := postgres.New(postgres.Config{
+		Conn:       mockDB,
+		DriverName: "postgres",
+	})
+
+	gormDB, err := gorm.Open(dialector, &gorm.Config{})
+	assert.Nil(t, err, "Failed to open GORM DB: %v", err)
+
+	server := &userServiceServer{Store: newGormStore(gormDB)}
+
+	req := &pb.PatchUserRequest{
+		Id:         1,
+		User:       &pb.User{Age: -1},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"age"}},
+	}
+
+	resp, err := server.PatchUser(authenticated(1), req)
+
+	statusErr, ok := status.FromError(err)
+
+	assert.Error(t, err)
+	assert.Nil(t, resp)
+	assert.True(t, ok, "Expected gRPC status error")
+	assert.Equal(t, codes.InvalidArgument, statusErr.Code())
+	assert.Equal(t, "Invalid user data", statusErr.Message())
+}
+
+func TestPatchUser_success_OnlyUpdatesMaskedFields(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+
+	assert.Nil(t, err, "Failed to create mock DB: %v", err)
+	defer mockDB.Close()
+
+	dialector := postgres.New(postgres.Config{
+		Conn:       mockDB,
+		DriverName: "postgres",
+	})
+
+	gormDB, err := gorm.Open(dialector, &gorm.Config{})
+	assert.Nil(t, err, "Failed to open GORM DB: %v", err)
+
+	server := &userServiceServer{Store: newGormStore(gormDB)}
+
+	rows := sqlmock.NewRows([]string{"id", "first_name", "last_name", "age", "version"}).AddRow(1, "Cool", "Kid", 12, 1)
+	mock.ExpectQuery("SELECT").WillReturnRows(rows)
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "users" SET "age"=\$1,"updated_at"=\$2,"version"=version \+ 1 WHERE \(id = \$3 AND version = \$4\)`).
+		WithArgs(30, sqlmock.AnyArg(), 1, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	req := &pb.PatchUserRequest{
+		Id:         1,
+		User:       &pb.User{Age: 30, Version: 1},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"age"}},
+	}
+
+	resp, err := server.PatchUser(authenticated(1), req)
+
+	assert.NoError(t, err)
+	assert.NotNil(t, resp)
+	assert.Equal(t, "Cool", resp.User.FirstName)
+	assert.Equal(t, "Kid", resp.User.LastName)
+	assert.Equal(t, int32(30), resp.User.Age)
+	assert.Equal(t, int64(2), resp.User.Version)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPatchUser_MissingVersion_ReturnsFailedPrecondition(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+
+	assert.Nil(t, err, "Failed to create mock DB: %v", err)
+	defer mockDB.Close()
+
+	dialector := postgres.New(postgres.Config{
+		Conn:       mockDB,
+		DriverName: "postgres",
+	})
+
+	gormDB, err := gorm.Open(dialector, &gorm.Config{})
+	assert.Nil(t, err, "Failed to open GORM DB: %v", err)
+
+	server := &userServiceServer{Store: newGormStore(gormDB)}
+
+	req := &pb.PatchUserRequest{
+		Id:         1,
+		User:       &pb.User{Age: 30},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"age"}},
+	}
+
+	resp, err := server.PatchUser(authenticated(1), req)
+
+	assert.Nil(t, resp)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.NoError(t, mock.ExpectationsWereMet(), "Expected no query without a version")
+}
+
+func TestPatchUser_StaleVersion_ReturnsAborted(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+
+	assert.Nil(t, err, "Failed to create mock DB: %v", err)
+	defer mockDB.Close()
+
+	dialector := postgres.New(postgres.Config{
+		Conn:       mockDB,
+		DriverName: "postgres",
+	})
+
+	gormDB, err := gorm.Open(dialector, &gorm.Config{})
+	assert.Nil(t, err, "Failed to open GORM DB: %v", err)
+
+	server := &userServiceServer{Store: newGormStore(gormDB)}
+
+	rows := sqlmock.NewRows([]string{"id", "first_name", "last_name", "age", "version"}).AddRow(1, "Cool", "Kid", 12, 4)
+	mock.ExpectQuery("SELECT").WillReturnRows(rows)
+
+	req := &pb.PatchUserRequest{
+		Id:         1,
+		User:       &pb.User{Age: 30, Version: 3},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"age"}},
+	}
+
+	resp, err := server.PatchUser(authenticated(1), req)
+
+	assert.Nil(t, resp)
+	assert.Equal(t, codes.Aborted, status.Code(err))
+	assert.NoError(t, mock.ExpectationsWereMet())
 }
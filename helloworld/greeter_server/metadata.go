@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func incomingHeader(ctx context.Context, key string) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+
+	return ""
+}
+
+// bearerToken returns the token of the authorization metadata, which the
+// REST gateway forwards from the Authorization header.
+func bearerToken(ctx context.Context) string {
+	scheme, credentials, ok := strings.Cut(incomingHeader(ctx, "authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+
+	return strings.TrimSpace(credentials)
+}
+
+// requestVersion returns the version from an If-Match ETag forwarded by the
+// REST gateway, or else the version sent in the request. "*" matches any
+// version, like a missing header.
+func requestVersion(ctx context.Context, version int64) (int64, error) {
+	header := strings.TrimPrefix(strings.TrimSpace(incomingHeader(ctx, "if-match")), "W/")
+	if header == "" || header == "*" {
+		return version, nil
+	}
+
+	unquoted, err := strconv.Unquote(header)
+	if err != nil {
+		return 0, status.Errorf(codes.InvalidArgument, "Invalid If-Match header")
+	}
+
+	ifMatch, err := strconv.ParseInt(unquoted, 10, 64)
+	if err != nil || ifMatch <= 0 {
+		return 0, status.Errorf(codes.InvalidArgument, "Invalid If-Match header")
+	}
+
+	return ifMatch, nil
+}
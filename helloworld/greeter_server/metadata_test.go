@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestBearerToken_ReadsAuthorizationMetadata(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer secret"))
+
+	assert.Equal(t, "secret", bearerToken(ctx))
+	assert.Equal(t, "", bearerToken(context.Background()))
+
+	basic := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Basic c2VjcmV0"))
+	assert.Equal(t, "", bearerToken(basic))
+}
+
+func TestRequestVersion_PrefersIfMatch(t *testing.T) {
+	ifMatch := func(value string) context.Context {
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs("if-match", value))
+	}
+
+	version, err := requestVersion(context.Background(), 2)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), version)
+
+	version, err = requestVersion(ifMatch(`W/"5"`), 2)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), version)
+
+	version, err = requestVersion(ifMatch("*"), 2)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), version)
+
+	_, err = requestVersion(ifMatch("5"), 2)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRPCMetrics_CountsByMethodAndCode(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metrics := newRPCMetrics(registry)
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/helloworld.UserService/GetUser"}
+	ok := func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil }
+	notFound := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, status.Error(codes.NotFound, "user not found")
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err := metrics.unaryInterceptor(context.Background(), nil, info, ok)
+		require.NoError(t, err)
+	}
+
+	_, err := metrics.unaryInterceptor(context.Background(), nil, info, notFound)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.handled.WithLabelValues(info.FullMethod, "OK")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.handled.WithLabelValues(info.FullMethod, "NotFound")))
+	assert.Equal(t, 2, testutil.CollectAndCount(metrics.duration))
+}
+
+func TestRegisterStoreMetrics_ExportsPoolStats(t *testing.T) {
+	registry := prometheus.NewRegistry()
+
+	require.NoError(t, registerStoreMetrics(registry, newMemoryStore()))
+	require.NoError(t, registerStoreMetrics(registry, newMigratedSQLiteStore(t)))
+
+	err := testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP go_sql_max_open_connections Maximum number of open connections to the database.
+# TYPE go_sql_max_open_connections gauge
+go_sql_max_open_connections{db_name="users"} 0
+`), "go_sql_max_open_connections")
+	assert.NoError(t, err)
+}
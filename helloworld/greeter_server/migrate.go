@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Migrations live in migrations/<dialect>/NNNN_name.up.sql with a matching
+// NNNN_name.down.sql. Applied versions are recorded in schema_migrations.
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+// migrationLockID serialises concurrent migrators on Postgres.
+const migrationLockID = 7260351
+
+type migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+type migrationStatus struct {
+	migration
+	AppliedAt *time.Time
+}
+
+type schemaMigration struct {
+	Version   int64     `gorm:"column:version;primaryKey;autoIncrement:false"`
+	Name      string    `gorm:"column:name"`
+	AppliedAt time.Time `gorm:"column:applied_at"`
+}
+
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+type migrator struct {
+	db         *gorm.DB
+	dialect    string
+	migrations []migration
+}
+
+func newMigrator(db *gorm.DB) (*migrator, error) {
+	dialect := db.Dialector.Name()
+
+	migrations, err := loadMigrations(migrationFiles, path.Join("migrations", dialect))
+	if err != nil {
+		return nil, err
+	}
+
+	return &migrator{db: db, dialect: dialect, migrations: migrations}, nil
+}
+
+func loadMigrations(fsys fs.FS, dir string) ([]migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for %s: %w", path.Base(dir), err)
+	}
+
+	byVersion := map[int64]*migration{}
+
+	for _, entry := range entries {
+		name := entry.Name()
+
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+
+		prefix, rest, ok := strings.Cut(name, "_")
+		version, err := strconv.ParseInt(prefix, 10, 64)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: name must start with a positive version", name)
+		}
+
+		body, err := fs.ReadFile(fsys, path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{Version: version, Name: strings.TrimSuffix(rest, "."+direction+".sql")}
+			byVersion[version] = m
+		}
+
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	var migrations []migration
+
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d has no up script", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+func (m *migrator) applied(ctx context.Context) (map[int64]schemaMigration, error) {
+	err := m.db.WithContext(ctx).Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    BIGINT PRIMARY KEY,
+		name       TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL
+	)`).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []schemaMigration
+
+	if err := m.db.WithContext(ctx).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int64]schemaMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+
+	return applied, nil
+}
+
+func (m *migrator) Status(ctx context.Context) ([]migrationStatus, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]migrationStatus, len(m.migrations))
+
+	for i, mig := range m.migrations {
+		statuses[i].migration = mig
+		if row, ok := applied[mig.Version]; ok {
+			statuses[i].AppliedAt = &row.AppliedAt
+		}
+	}
+
+	return statuses, nil
+}
+
+func (m *migrator) Pending(ctx context.Context) ([]migration, error) {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []migration
+
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			pending = append(pending, status.migration)
+		}
+	}
+
+	return pending, nil
+}
+
+// Up applies every pending migration in order, each in its own transaction.
+func (m *migrator) Up(ctx context.Context) ([]migration, error) {
+	pending, err := m.Pending(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []migration
+
+	for _, mig := range pending {
+		err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if ok, err := m.lock(tx, mig.Version, true); err != nil || !ok {
+				return err
+			}
+
+			if err := tx.Exec(mig.Up).Error; err != nil {
+				return err
+			}
+
+			return tx.Create(&schemaMigration{Version: mig.Version, Name: mig.Name, AppliedAt: time.Now()}).Error
+		})
+
+		if err != nil {
+			return done, fmt.Errorf("migration %04d_%s: %w", mig.Version, mig.Name, err)
+		}
+
+		done = append(done, mig)
+	}
+
+	return done, nil
+}
+
+// Down rolls back the latest steps applied migrations.
+func (m *migrator) Down(ctx context.Context, steps int) ([]migration, error) {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []migration
+
+	for i := len(statuses) - 1; i >= 0 && len(done) < steps; i-- {
+		mig := statuses[i].migration
+		if statuses[i].AppliedAt == nil {
+			continue
+		}
+
+		if mig.Down == "" {
+			return done, fmt.Errorf("migration %04d_%s cannot be rolled back", mig.Version, mig.Name)
+		}
+
+		err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if ok, err := m.lock(tx, mig.Version, false); err != nil || !ok {
+				return err
+			}
+
+			if err := tx.Exec(mig.Down).Error; err != nil {
+				return err
+			}
+
+			return tx.Delete(&schemaMigration{}, mig.Version).Error
+		})
+
+		if err != nil {
+			return done, fmt.Errorf("migration %04d_%s: %w", mig.Version, mig.Name, err)
+		}
+
+		done = append(done, mig)
+	}
+
+	return done, nil
+}
+
+// lock serialises migrators on Postgres and reports whether the migration
+// still needs to run in the wanted direction once the lock is held.
+func (m *migrator) lock(tx *gorm.DB, version int64, up bool) (bool, error) {
+	if m.dialect == "postgres" {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", migrationLockID).Error; err != nil {
+			return false, err
+		}
+	}
+
+	var count int64
+	if err := tx.Model(&schemaMigration{}).Where("version = ?", version).Count(&count).Error; err != nil {
+		return false, err
+	}
+
+	return (count == 0) == up, nil
+}
+
+// runMigrateCommand implements "greeter_server migrate up|down [steps]|status".
+func runMigrateCommand(ctx context.Context, store UserStore, args []string) error {
+	gs, ok := store.(*gormStore)
+	if !ok {
+		return fmt.Errorf("the %T store has no schema to migrate", store)
+	}
+
+	m, err := newMigrator(gs.db)
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 {
+		return fmt.Errorf("usage: migrate up|down [steps]|status")
+	}
+
+	switch args[0] {
+	case "up":
+		done, err := m.Up(ctx)
+		for _, mig := range done {
+			fmt.Printf("applied  %04d_%s\n", mig.Version, mig.Name)
+		}
+		if err == nil && len(done) == 0 {
+			fmt.Println("schema is up to date")
+		}
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps <= 0 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+		}
+
+		done, err := m.Down(ctx, steps)
+		for _, mig := range done {
+			fmt.Printf("reverted %04d_%s\n", mig.Version, mig.Name)
+		}
+		return err
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = "applied " + status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%s\t%s\n", status.Version, status.Name, applied)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q", args[0])
+	}
+}
+
+// checkSchema refuses to serve from a database that is missing migrations.
+func checkSchema(ctx context.Context, store UserStore) error {
+	gs, ok := store.(*gormStore)
+	if !ok {
+		return nil
+	}
+
+	m, err := newMigrator(gs.db)
+	if err != nil {
+		return err
+	}
+
+	pending, err := m.Pending(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(pending) > 0 {
+		return fmt.Errorf("database schema is behind by %d migration(s), run \"greeter_server migrate up\"", len(pending))
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMigrations_OrdersAndPairsScripts(t *testing.T) {
+	fsys := fstest.MapFS{
+		"m/0002_second.up.sql":   {Data: []byte("up 2")},
+		"m/0001_first.up.sql":    {Data: []byte("up 1")},
+		"m/0001_first.down.sql":  {Data: []byte("down 1")},
+		"m/README.md":            {Data: []byte("ignored")},
+		"m/0002_second.down.sql": {Data: []byte("down 2")},
+	}
+
+	migrations, err := loadMigrations(fsys, "m")
+	require.NoError(t, err)
+	require.Len(t, migrations, 2)
+
+	assert.Equal(t, migration{Version: 1, Name: "first", Up: "up 1", Down: "down 1"}, migrations[0])
+	assert.Equal(t, migration{Version: 2, Name: "second", Up: "up 2", Down: "down 2"}, migrations[1])
+}
+
+func TestLoadMigrations_RejectsBadNames(t *testing.T) {
+	_, err := loadMigrations(fstest.MapFS{"m/first.up.sql": {Data: []byte("up")}}, "m")
+	assert.Error(t, err)
+
+	_, err = loadMigrations(fstest.MapFS{"m/0001_first.down.sql": {Data: []byte("down")}}, "m")
+	assert.Error(t, err)
+}
+
+func TestMigrator_UpStatusDown(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := newSQLiteStore(filepath.Join(t.TempDir(), "users.db"))
+	require.NoError(t, err)
+	defer store.Close()
+
+	m, err := newMigrator(store.db)
+	require.NoError(t, err)
+	require.NotEmpty(t, m.migrations)
+
+	assert.Error(t, checkSchema(ctx, store))
+
+	done, err := m.Up(ctx)
+	require.NoError(t, err)
+	assert.Len(t, done, len(m.migrations))
+
+	pending, err := m.Pending(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+	assert.NoError(t, checkSchema(ctx, store))
+
+	done, err = m.Up(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, done)
+
+	require.NoError(t, store.Create(ctx, &userRecord{FirstName: "Cool", LastName: "Kid", Age: 10, Version: 1}))
+
+	done, err = m.Down(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, done, 1)
+	assert.Equal(t, m.migrations[len(m.migrations)-1].Version, done[0].Version)
+
+	statuses, err := m.Status(ctx)
+	require.NoError(t, err)
+	assert.NotNil(t, statuses[0].AppliedAt)
+	assert.Nil(t, statuses[len(statuses)-1].AppliedAt)
+
+	_, err = m.Down(ctx, len(m.migrations))
+	require.NoError(t, err)
+	assert.False(t, store.db.Migrator().HasTable("users"))
+}
+
+func TestCheckSchema_SkipsMemoryStore(t *testing.T) {
+	assert.NoError(t, checkSchema(context.Background(), newMemoryStore()))
+}
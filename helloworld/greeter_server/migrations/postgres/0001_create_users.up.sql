@@ -0,0 +1,17 @@
+-- Matches the table previously created by gorm's AutoMigrate, so existing
+-- databases are adopted as-is.
+CREATE TABLE IF NOT EXISTS users (
+    id         BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ,
+    first_name TEXT,
+    last_name  TEXT,
+    age        INTEGER,
+    token      TEXT,
+    version    BIGINT NOT NULL DEFAULT 1
+);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;
+
+CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users (deleted_at);
@@ -0,0 +1,3 @@
+-- Hashed tokens cannot be restored; their users need new tokens.
+DROP INDEX IF EXISTS idx_users_token_hash;
+ALTER TABLE users DROP COLUMN token_hash;
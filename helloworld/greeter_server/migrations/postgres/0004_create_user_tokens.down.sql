@@ -0,0 +1,12 @@
+-- Keeps the newest unrevoked token of every user.
+ALTER TABLE users ADD COLUMN token_hash TEXT;
+
+UPDATE users SET token_hash = (
+    SELECT token_hash FROM user_tokens
+    WHERE user_tokens.user_id = users.id AND revoked_at IS NULL
+    ORDER BY issued_at DESC
+    LIMIT 1
+);
+
+CREATE INDEX IF NOT EXISTS idx_users_token_hash ON users (token_hash);
+DROP TABLE IF EXISTS user_tokens;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_users_age_id;
+DROP INDEX IF EXISTS idx_users_last_name_id;
@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS idx_users_last_name_id ON users (last_name, id);
+CREATE INDEX IF NOT EXISTS idx_users_age_id ON users (age, id);
@@ -0,0 +1,4 @@
+-- token keeps the plaintext tokens of existing users until the server moves
+-- them into token_hash on startup, which needs the token pepper.
+ALTER TABLE users ADD COLUMN token_hash TEXT;
+CREATE INDEX IF NOT EXISTS idx_users_token_hash ON users (token_hash);
@@ -0,0 +1,21 @@
+-- Users can hold several tokens while one is rotated. Existing tokens are
+-- moved over and do not expire.
+CREATE TABLE IF NOT EXISTS user_tokens (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id    INTEGER NOT NULL REFERENCES users (id),
+    token_hash TEXT NOT NULL,
+    issued_at  DATETIME NOT NULL,
+    expires_at DATETIME,
+    revoked_at DATETIME
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_tokens_token_hash ON user_tokens (token_hash);
+CREATE INDEX IF NOT EXISTS idx_user_tokens_user_id ON user_tokens (user_id);
+
+INSERT INTO user_tokens (user_id, token_hash, issued_at)
+SELECT id, token_hash, COALESCE(created_at, CURRENT_TIMESTAMP)
+FROM users
+WHERE token_hash IS NOT NULL AND token_hash <> '';
+
+DROP INDEX IF EXISTS idx_users_token_hash;
+ALTER TABLE users DROP COLUMN token_hash;
@@ -0,0 +1,6 @@
+-- Usernames are unique among users that are not deleted. An empty
+-- password_hash means the user has no password.
+ALTER TABLE users ADD COLUMN username TEXT;
+ALTER TABLE users ADD COLUMN password_hash TEXT NOT NULL DEFAULT '';
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username ON users (username) WHERE deleted_at IS NULL;
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"time"
+
+	pb "google.golang.org/grpc/examples/helloworld/helloworld"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+)
+
+// userRecord is the users table row. RPCs never read or write pb.User through
+// gorm; they convert with userRecordToProto and userRecordFromProto so the
+// table and the wire schema can change independently.
+type userRecord struct {
+	ID        uint           `gorm:"column:id;primaryKey"`
+	CreatedAt time.Time      `gorm:"column:created_at"`
+	UpdatedAt time.Time      `gorm:"column:updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"column:deleted_at;index"`
+	FirstName string         `gorm:"column:first_name"`
+	LastName  string         `gorm:"column:last_name"`
+	Age       int32          `gorm:"column:age"`
+	Version   int64          `gorm:"column:version;not null;default:1"`
+	// Username is nil for users without one, since it must be unique.
+	Username     *string `gorm:"column:username"`
+	PasswordHash string  `gorm:"column:password_hash"`
+	// Tokens are inserted with the user by UserStore.Create and not loaded
+	// otherwise.
+	Tokens []userToken `gorm:"-"`
+}
+
+func (userRecord) TableName() string {
+	return "users"
+}
+
+// userToken is a user_tokens row. Only the hash of the token is stored.
+type userToken struct {
+	ID        uint       `gorm:"column:id;primaryKey"`
+	UserID    uint       `gorm:"column:user_id"`
+	TokenHash string     `gorm:"column:token_hash"`
+	IssuedAt  time.Time  `gorm:"column:issued_at"`
+	ExpiresAt *time.Time `gorm:"column:expires_at"`
+	RevokedAt *time.Time `gorm:"column:revoked_at"`
+}
+
+func (userToken) TableName() string {
+	return "user_tokens"
+}
+
+// active reports whether the token is neither revoked nor expired at now.
+func (t userToken) active(now time.Time) bool {
+	return t.RevokedAt == nil && (t.ExpiresAt == nil || now.Before(*t.ExpiresAt))
+}
+
+// userRecordToProto never copies the token; it is only handed out by
+// CreateUser.
+func userRecordToProto(record userRecord) *pb.User {
+	return &pb.User{
+		Id:        int64(record.ID),
+		FirstName: record.FirstName,
+		LastName:  record.LastName,
+		Age:       record.Age,
+		Version:   record.Version,
+		CreatedAt: timestamppb.New(record.CreatedAt),
+		UpdatedAt: timestamppb.New(record.UpdatedAt),
+		Username:  record.username(),
+	}
+}
+
+func (u userRecord) username() string {
+	if u.Username == nil {
+		return ""
+	}
+	return *u.Username
+}
+
+// userRecordFromProto copies the caller-editable fields. The id, version,
+// token and timestamps are owned by the server.
+func userRecordFromProto(user *pb.User) userRecord {
+	record := userRecord{
+		FirstName: user.GetFirstName(),
+		LastName:  user.GetLastName(),
+		Age:       user.GetAge(),
+	}
+
+	if username := user.GetUsername(); username != "" {
+		record.Username = &username
+	}
+
+	return record
+}
+
+func optionalTimestamp(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
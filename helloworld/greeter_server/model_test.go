@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	pb "google.golang.org/grpc/examples/helloworld/helloworld"
+)
+
+func TestUserRecordToProto_CopiesFieldsAndTimestamps(t *testing.T) {
+	created := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	updated := created.Add(time.Hour)
+
+	user := userRecordToProto(userRecord{
+		ID:        7,
+		CreatedAt: created,
+		UpdatedAt: updated,
+		FirstName: "Cool",
+		LastName:  "Kid",
+		Age:       10,
+		Version:   3,
+	})
+
+	assert.Equal(t, int64(7), user.Id)
+	assert.Equal(t, "Cool", user.FirstName)
+	assert.Equal(t, "Kid", user.LastName)
+	assert.Equal(t, int32(10), user.Age)
+	assert.Equal(t, int64(3), user.Version)
+	assert.Equal(t, created, user.CreatedAt.AsTime())
+	assert.Equal(t, updated, user.UpdatedAt.AsTime())
+}
+
+func TestUserRecordFromProto_IgnoresServerOwnedFields(t *testing.T) {
+	record := userRecordFromProto(&pb.User{
+		Id:        7,
+		FirstName: "Cool",
+		LastName:  "Kid",
+		Age:       10,
+		Version:   9,
+	})
+
+	assert.Equal(t, userRecord{FirstName: "Cool", LastName: "Kid", Age: 10}, record)
+}
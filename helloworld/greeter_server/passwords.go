@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/argon2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Passwords are hashed with argon2id and stored in the PHC string format, so
+// hashes keep working if these parameters are raised later.
+const (
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024
+	argon2Threads = 2
+	argon2KeyLen  = 32
+	argon2SaltLen = 16
+)
+
+// maxPasswordLength caps passwords even when the policy sets no maximum, so
+// one request cannot make the server hash an arbitrarily long input.
+const maxPasswordLength = 1024
+
+// maxConcurrentHashes bounds the argon2id computations in flight, as each
+// one holds argon2Memory KiB until it finishes.
+const maxConcurrentHashes = 4
+
+var hashSlots = make(chan struct{}, maxConcurrentHashes)
+
+// acquireHashSlot waits until fewer than maxConcurrentHashes hashes are
+// running or ctx is done. Calling the returned func frees the slot.
+func acquireHashSlot(ctx context.Context) (func(), error) {
+	select {
+	case hashSlots <- struct{}{}:
+		return func() { <-hashSlots }, nil
+	case <-ctx.Done():
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+// passwordPolicy is what a password must satisfy to be set. MinClasses counts
+// the classes lowercase, uppercase, digit and other that appear.
+type passwordPolicy struct {
+	MinLength  int
+	MaxLength  int
+	MinClasses int
+}
+
+func (p passwordPolicy) validate(password string) error {
+	length := utf8.RuneCountInString(password)
+
+	if length == 0 || length < p.MinLength {
+		return status.Errorf(codes.InvalidArgument, "Password must be at least %d characters", max(p.MinLength, 1))
+	}
+
+	if err := p.validateMaxLength(password); err != nil {
+		return err
+	}
+
+	if passwordClasses(password) < p.MinClasses {
+		return status.Errorf(codes.InvalidArgument, "Password must mix at least %d of lowercase, uppercase, digits and other characters", p.MinClasses)
+	}
+
+	return nil
+}
+
+// validateMaxLength rejects passwords longer than the policy allows, or than
+// maxPasswordLength without a policy maximum. Login checks it before hashing
+// too.
+func (p passwordPolicy) validateMaxLength(password string) error {
+	limit := p.MaxLength
+	if limit <= 0 {
+		limit = maxPasswordLength
+	}
+
+	if utf8.RuneCountInString(password) > limit {
+		return status.Errorf(codes.InvalidArgument, "Password must be at most %d characters", limit)
+	}
+
+	return nil
+}
+
+func passwordClasses(password string) int {
+	var lower, upper, digit, other int
+
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+
+	return lower + upper + digit + other
+}
+
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, argon2Memory, argon2Time, argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// checkPassword reports, in constant time, whether password hashes to hash
+// with the parameters stored in it. An empty hash matches nothing.
+func checkPassword(hash string, password string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return false
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false
+	}
+
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false
+	}
+
+	want, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(want) == 0 {
+		return false
+	}
+
+	got := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(want)))
+
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// dummyPasswordHash is checked against when a login names an unknown user or
+// one without a password, so they take as long to reject as wrong passwords.
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, _ := hashPassword(newToken())
+	return hash
+})
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	pb "google.golang.org/grpc/examples/helloworld/helloworld"
+	"google.golang.org/grpc/status"
+)
+
+func TestHashPassword_IsSaltedArgon2id(t *testing.T) {
+	hash, err := hashPassword("correct horse")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=65536,t=3,p=2$"), hash)
+
+	other, err := hashPassword("correct horse")
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, other)
+
+	assert.True(t, checkPassword(hash, "correct horse"))
+	assert.True(t, checkPassword(other, "correct horse"))
+	assert.False(t, checkPassword(hash, "wrong"))
+	assert.False(t, checkPassword("", ""))
+	assert.False(t, checkPassword("$2a$10$not-argon", "correct horse"))
+}
+
+func TestPasswordPolicy_Validate(t *testing.T) {
+	policy := passwordPolicy{MinLength: 8, MaxLength: 16, MinClasses: 3}
+
+	assert.NoError(t, policy.validate("Horse-battery"))
+	assert.EqualError(t, policy.validate("Hor-1"), "rpc error: code = InvalidArgument desc = Password must be at least 8 characters")
+	assert.EqualError(t, policy.validate(strings.Repeat("Ab1", 6)), "rpc error: code = InvalidArgument desc = Password must be at most 16 characters")
+	assert.Equal(t, codes.InvalidArgument, status.Code(policy.validate("horsebattery")))
+	assert.Equal(t, codes.InvalidArgument, status.Code(passwordPolicy{}.validate("")))
+	assert.EqualError(t, passwordPolicy{}.validate(strings.Repeat("a", maxPasswordLength+1)), "rpc error: code = InvalidArgument desc = Password must be at most 1024 characters")
+}
+
+func TestLogin_ExchangesPasswordForToken(t *testing.T) {
+	server := &userServiceServer{Store: newMemoryStore(), events: newUserEvents(10), passwords: passwordPolicy{MinLength: 8}}
+	client := newAuthTestClient(t, server)
+	ctx := context.Background()
+
+	created, err := client.CreateUser(ctx, &pb.CreateUserRequest{
+		User:     &pb.User{FirstName: "Cool", LastName: "Kid", Age: 10, Username: "coolkid"},
+		Password: "correct horse",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "coolkid", created.User.Username)
+
+	byName, err := client.Login(ctx, &pb.LoginRequest{User: &pb.LoginRequest_Username{Username: "coolkid"}, Password: "correct horse"})
+	require.NoError(t, err)
+	assert.Equal(t, created.User.Id, byName.Id)
+	assert.NotEqual(t, created.Token, byName.Token)
+
+	byID, err := client.Login(ctx, &pb.LoginRequest{User: &pb.LoginRequest_Id{Id: created.User.Id}, Password: "correct horse"})
+	require.NoError(t, err)
+
+	for _, token := range []string{created.Token, byName.Token, byID.Token} {
+		_, err = client.GetUser(withBearer(token), &pb.GetUserRequest{Id: created.User.Id})
+		assert.NoError(t, err, "Expected every token to keep working after a login")
+	}
+
+	for _, req := range []*pb.LoginRequest{
+		{User: &pb.LoginRequest_Username{Username: "coolkid"}, Password: "wrong"},
+		{User: &pb.LoginRequest_Username{Username: "nobody"}, Password: "correct horse"},
+		{User: &pb.LoginRequest_Id{Id: created.User.Id + 1}, Password: "correct horse"},
+	} {
+		_, err = client.Login(ctx, req)
+		assert.EqualError(t, err, "rpc error: code = Unauthenticated desc = Invalid credentials")
+	}
+
+	_, err = client.Login(ctx, &pb.LoginRequest{Password: "correct horse"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestLogin_BoundsPasswordHashing(t *testing.T) {
+	server := &userServiceServer{Store: newMemoryStore(), passwords: passwordPolicy{MinLength: 8, MaxLength: 16}}
+	ctx := context.Background()
+
+	created, err := server.CreateUser(ctx, &pb.CreateUserRequest{
+		User:     &pb.User{FirstName: "Cool", LastName: "Kid", Age: 10, Username: "coolkid"},
+		Password: "correct horse",
+	})
+	require.NoError(t, err)
+
+	_, err = server.Login(ctx, &pb.LoginRequest{User: &pb.LoginRequest_Id{Id: created.User.Id}, Password: strings.Repeat("a", 17)})
+	assert.EqualError(t, err, "rpc error: code = InvalidArgument desc = Password must be at most 16 characters")
+
+	for i := 0; i < maxConcurrentHashes; i++ {
+		hashSlots <- struct{}{}
+	}
+	defer func() {
+		for i := 0; i < maxConcurrentHashes; i++ {
+			<-hashSlots
+		}
+	}()
+
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+
+	_, err = server.Login(timeout, &pb.LoginRequest{User: &pb.LoginRequest_Id{Id: created.User.Id}, Password: "correct horse"})
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err), "Expected logins to wait for a free hashing slot")
+}
+
+func TestLogin_UserWithoutPassword_IsRejected(t *testing.T) {
+	server := &userServiceServer{Store: newMemoryStore()}
+	ctx := context.Background()
+
+	created, err := server.CreateUser(ctx, &pb.CreateUserRequest{User: &pb.User{FirstName: "Cool", LastName: "Kid", Age: 10}})
+	require.NoError(t, err)
+
+	_, err = server.Login(ctx, &pb.LoginRequest{User: &pb.LoginRequest_Id{Id: created.User.Id}})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestCreateUser_PasswordAndUsername_AreValidated(t *testing.T) {
+	server := &userServiceServer{Store: newMemoryStore(), passwords: passwordPolicy{MinLength: 12}}
+	ctx := context.Background()
+	user := func(username string) *pb.User {
+		return &pb.User{FirstName: "Cool", LastName: "Kid", Age: 10, Username: username}
+	}
+
+	_, err := server.CreateUser(ctx, &pb.CreateUserRequest{User: user("coolkid"), Password: "short"})
+	assert.EqualError(t, err, "rpc error: code = InvalidArgument desc = Password must be at least 12 characters")
+
+	_, err = server.CreateUser(ctx, &pb.CreateUserRequest{User: user("42")})
+	assert.EqualError(t, err, "rpc error: code = InvalidArgument desc = Invalid username")
+
+	_, err = server.CreateUser(ctx, &pb.CreateUserRequest{User: user("coolkid"), Password: "correct horse"})
+	require.NoError(t, err)
+
+	_, err = server.CreateUser(ctx, &pb.CreateUserRequest{User: user("coolkid")})
+	assert.EqualError(t, err, "rpc error: code = AlreadyExists desc = Username is taken")
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	errUserNotFound    = errors.New("user not found")
+	errUserNotCreated  = errors.New("cannot create user successfully")
+	errVersionConflict = errors.New("user has been modified")
+	errTokenNotFound   = errors.New("token not found")
+	errUsernameTaken   = errors.New("username is taken")
+)
+
+// UserStore persists users. Implementations must treat deleted users as not
+// found.
+type UserStore interface {
+	// Create inserts all users and their tokens or none of them, filling in
+	// their ids and timestamps. Users with a non-zero ID keep it. It fails
+	// with errUsernameTaken if an existing user has the username of one.
+	Create(ctx context.Context, users ...*userRecord) error
+	Get(ctx context.Context, id int64) (userRecord, error)
+	FindByUsername(ctx context.Context, username string) (userRecord, error)
+	// FindToken returns the token of an existing user with the given hash,
+	// whether or not it is still active.
+	FindToken(ctx context.Context, hash string) (userToken, error)
+	// RotateToken inserts token and makes the other active tokens of its
+	// user expire at expireOthersAt at the latest.
+	RotateToken(ctx context.Context, token *userToken, expireOthersAt time.Time) error
+	// AddToken inserts token and leaves the other tokens of its user alone.
+	AddToken(ctx context.Context, token *userToken) error
+	// RevokeTokens revokes the unrevoked tokens of a user, only the one with
+	// the given hash if it is not empty, and returns how many it revoked.
+	RevokeTokens(ctx context.Context, userID int64, hash string) (int64, error)
+	// Update writes the named columns of user if the stored version still
+	// matches user.Version, then bumps user.Version and user.UpdatedAt.
+	Update(ctx context.Context, user *userRecord, columns ...string) error
+	Delete(ctx context.Context, id int64) error
+	List(ctx context.Context, query userQuery) ([]userRecord, error)
+}
+
+// userQuery filters and orders ListUsers. Results continue after the user
+// with id After in the requested order; zero starts at the beginning. A
+// non-zero ID only matches that user.
+type userQuery struct {
+	Limit    int
+	ID       int64
+	After    int64
+	LastName string
+	MinAge   int32
+	MaxAge   int32
+	OrderBy  string
+	Desc     bool
+}
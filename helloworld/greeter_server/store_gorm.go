@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/plugin/opentelemetry/tracing"
+)
+
+// gormStore is the UserStore for SQL databases: Postgres in production and
+// SQLite for local development.
+type gormStore struct {
+	db *gorm.DB
+}
+
+func newGormStore(db *gorm.DB) *gormStore {
+	return &gormStore{db: db}
+}
+
+func newPostgresStore(dsn string) (*gormStore, error) {
+	return openGormStore(postgres.Open(dsn))
+}
+
+func newSQLiteStore(path string) (*gormStore, error) {
+	return openGormStore(sqlite.Open(path))
+}
+
+func openGormStore(dialector gorm.Dialector) (*gormStore, error) {
+	db, err := gorm.Open(dialector, &gorm.Config{
+		Logger: logger.NewSlogLogger(slog.Default(), logger.Config{
+			LogLevel:                  logger.Warn,
+			SlowThreshold:             200 * time.Millisecond,
+			IgnoreRecordNotFoundError: true,
+			// Keep tokens and other values out of the logs.
+			ParameterizedQueries: true,
+		}),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Use(tracing.NewPlugin(tracing.WithoutMetrics())); err != nil {
+		return nil, err
+	}
+
+	return newGormStore(db), nil
+}
+
+func (s *gormStore) Create(ctx context.Context, users ...*userRecord) error {
+	if len(users) == 0 {
+		return nil
+	}
+
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Create(users)
+
+		if result.Error != nil && usernameConflict(result.Error) {
+			return errUsernameTaken
+		}
+
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected != int64(len(users)) {
+			return errUserNotCreated
+		}
+
+		var tokens []*userToken
+		for _, user := range users {
+			for i := range user.Tokens {
+				user.Tokens[i].UserID = user.ID
+				tokens = append(tokens, &user.Tokens[i])
+			}
+		}
+
+		if len(tokens) == 0 {
+			return nil
+		}
+
+		return tx.Create(tokens).Error
+	})
+}
+
+func (s *gormStore) Get(ctx context.Context, id int64) (userRecord, error) {
+	var user userRecord
+
+	result := s.db.WithContext(ctx).Limit(1).Find(&user, id)
+
+	if result.Error != nil {
+		return userRecord{}, result.Error
+	}
+
+	if user.ID == 0 {
+		return userRecord{}, errUserNotFound
+	}
+
+	return user, nil
+}
+
+// usernameConflict recognizes violations of idx_users_username as Postgres and
+// SQLite report them.
+func usernameConflict(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "idx_users_username") || strings.Contains(msg, "users.username")
+}
+
+func (s *gormStore) FindByUsername(ctx context.Context, username string) (userRecord, error) {
+	var user userRecord
+
+	result := s.db.WithContext(ctx).Where("username = ?", username).Limit(1).Find(&user)
+
+	if result.Error != nil {
+		return userRecord{}, result.Error
+	}
+
+	if user.ID == 0 {
+		return userRecord{}, errUserNotFound
+	}
+
+	return user, nil
+}
+
+func (s *gormStore) FindToken(ctx context.Context, hash string) (userToken, error) {
+	var token userToken
+
+	result := s.db.WithContext(ctx).
+		Joins("JOIN users ON users.id = user_tokens.user_id AND users.deleted_at IS NULL").
+		Where("user_tokens.token_hash = ?", hash).
+		Limit(1).
+		Find(&token)
+
+	if result.Error != nil {
+		return userToken{}, result.Error
+	}
+
+	if token.ID == 0 {
+		return userToken{}, errTokenNotFound
+	}
+
+	return token, nil
+}
+
+func (s *gormStore) RotateToken(ctx context.Context, token *userToken, expireOthersAt time.Time) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&userToken{}).
+			Where("user_id = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", token.UserID, expireOthersAt).
+			Update("expires_at", expireOthersAt).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Create(token).Error
+	})
+}
+
+func (s *gormStore) AddToken(ctx context.Context, token *userToken) error {
+	return s.db.WithContext(ctx).Create(token).Error
+}
+
+func (s *gormStore) RevokeTokens(ctx context.Context, userID int64, hash string) (int64, error) {
+	query := s.db.WithContext(ctx).Model(&userToken{}).Where("user_id = ? AND revoked_at IS NULL", userID)
+	if hash != "" {
+		query = query.Where("token_hash = ?", hash)
+	}
+
+	result := query.Update("revoked_at", time.Now())
+
+	return result.RowsAffected, result.Error
+}
+
+func (s *gormStore) Update(ctx context.Context, user *userRecord, columns ...string) error {
+	now := time.Now()
+
+	updates := map[string]interface{}{
+		"version":    gorm.Expr("version + 1"),
+		"updated_at": now,
+	}
+
+	for _, column := range columns {
+		switch column {
+		case "first_name":
+			updates[column] = user.FirstName
+		case "last_name":
+			updates[column] = user.LastName
+		case "age":
+			updates[column] = user.Age
+		default:
+			return fmt.Errorf("cannot update column %q", column)
+		}
+	}
+
+	result := s.db.WithContext(ctx).Model(&userRecord{}).Where("id = ? AND version = ?", user.ID, user.Version).Updates(updates)
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return errVersionConflict
+	}
+
+	user.Version++
+	user.UpdatedAt = now
+
+	return nil
+}
+
+func (s *gormStore) Delete(ctx context.Context, id int64) error {
+	result := s.db.WithContext(ctx).Delete(&userRecord{}, id)
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return errUserNotFound
+	}
+
+	return nil
+}
+
+func (s *gormStore) List(ctx context.Context, q userQuery) ([]userRecord, error) {
+	direction, cmp := "ASC", ">"
+	if q.Desc {
+		direction, cmp = "DESC", "<"
+	}
+
+	query := s.db.WithContext(ctx).Model(&userRecord{})
+
+	if q.ID != 0 {
+		query = query.Where("id = ?", q.ID)
+	}
+
+	if q.LastName != "" {
+		query = query.Where("last_name = ?", q.LastName)
+	}
+
+	if q.MinAge > 0 {
+		query = query.Where("age >= ?", q.MinAge)
+	}
+
+	if q.MaxAge > 0 {
+		query = query.Where("age <= ?", q.MaxAge)
+	}
+
+	if q.After != 0 {
+		if q.OrderBy == "id" {
+			query = query.Where("id "+cmp+" ?", q.After)
+		} else {
+			// Keyset on (column, id) of the cursor row; it may have been
+			// deleted since, so look it up without the soft-delete scope.
+			query = query.Where(fmt.Sprintf("(%s, id) %s (SELECT %s, id FROM users WHERE id = ?)", q.OrderBy, cmp, q.OrderBy), q.After)
+		}
+	}
+
+	if q.OrderBy != "id" {
+		query = query.Order(q.OrderBy + " " + direction)
+	}
+
+	var users []userRecord
+
+	result := query.Order("id " + direction).Limit(q.Limit).Find(&users)
+
+	return users, result.Error
+}
+
+func (s *gormStore) Ping(ctx context.Context) error {
+	db, err := s.db.DB()
+	if err != nil {
+		return err
+	}
+
+	return db.PingContext(ctx)
+}
+
+func (s *gormStore) Close() error {
+	db, err := s.db.DB()
+	if err != nil {
+		return err
+	}
+
+	return db.Close()
+}
+
+var _ UserStore = (*gormStore)(nil)
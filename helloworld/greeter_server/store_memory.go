@@ -0,0 +1,295 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// memoryStore is a UserStore kept in process memory, for tests and for
+// running the server without a database.
+type memoryStore struct {
+	mu          sync.RWMutex
+	users       map[int64]userRecord
+	tokens      map[string]userToken
+	nextID      int64
+	nextTokenID uint
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{users: make(map[int64]userRecord), tokens: make(map[string]userToken)}
+}
+
+func (s *memoryStore) Create(ctx context.Context, users ...*userRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seen := make(map[int64]bool, len(users))
+	taken := make(map[string]bool)
+
+	for _, user := range users {
+		if user.Username != nil {
+			if _, err := s.findByUsername(*user.Username); err == nil || taken[*user.Username] {
+				return errUsernameTaken
+			}
+			taken[*user.Username] = true
+		}
+
+		if user.ID == 0 {
+			continue
+		}
+
+		id := int64(user.ID)
+		if _, ok := s.users[id]; ok || seen[id] {
+			return fmt.Errorf("duplicate user id %d", id)
+		}
+		seen[id] = true
+	}
+
+	now := time.Now()
+
+	for _, user := range users {
+		if user.ID == 0 {
+			s.nextID++
+			for s.users[s.nextID].ID != 0 || seen[s.nextID] {
+				s.nextID++
+			}
+			user.ID = uint(s.nextID)
+		}
+
+		user.CreatedAt = now
+		user.UpdatedAt = now
+		if user.Version == 0 {
+			user.Version = 1
+		}
+
+		for i := range user.Tokens {
+			s.nextTokenID++
+			user.Tokens[i].ID = s.nextTokenID
+			user.Tokens[i].UserID = user.ID
+			s.tokens[user.Tokens[i].TokenHash] = user.Tokens[i]
+		}
+
+		stored := *user
+		stored.Tokens = nil
+		s.users[int64(user.ID)] = stored
+	}
+
+	return nil
+}
+
+func (s *memoryStore) Get(ctx context.Context, id int64) (userRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[id]
+	if !ok || user.DeletedAt.Valid {
+		return userRecord{}, errUserNotFound
+	}
+
+	return user, nil
+}
+
+func (s *memoryStore) FindByUsername(ctx context.Context, username string) (userRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.findByUsername(username)
+}
+
+func (s *memoryStore) findByUsername(username string) (userRecord, error) {
+	for _, user := range s.users {
+		if !user.DeletedAt.Valid && user.Username != nil && *user.Username == username {
+			return user, nil
+		}
+	}
+
+	return userRecord{}, errUserNotFound
+}
+
+func (s *memoryStore) FindToken(ctx context.Context, hash string) (userToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	token, ok := s.tokens[hash]
+	if !ok {
+		return userToken{}, errTokenNotFound
+	}
+
+	if user, ok := s.users[int64(token.UserID)]; !ok || user.DeletedAt.Valid {
+		return userToken{}, errTokenNotFound
+	}
+
+	return token, nil
+}
+
+func (s *memoryStore) RotateToken(ctx context.Context, token *userToken, expireOthersAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.tokens[token.TokenHash]; ok {
+		return fmt.Errorf("duplicate token")
+	}
+
+	for hash, other := range s.tokens {
+		if other.UserID == token.UserID && other.RevokedAt == nil && (other.ExpiresAt == nil || other.ExpiresAt.After(expireOthersAt)) {
+			other.ExpiresAt = &expireOthersAt
+			s.tokens[hash] = other
+		}
+	}
+
+	s.nextTokenID++
+	token.ID = s.nextTokenID
+	s.tokens[token.TokenHash] = *token
+
+	return nil
+}
+
+func (s *memoryStore) AddToken(ctx context.Context, token *userToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.tokens[token.TokenHash]; ok {
+		return fmt.Errorf("duplicate token")
+	}
+
+	s.nextTokenID++
+	token.ID = s.nextTokenID
+	s.tokens[token.TokenHash] = *token
+
+	return nil
+}
+
+func (s *memoryStore) RevokeTokens(ctx context.Context, userID int64, hash string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var revoked int64
+
+	for h, token := range s.tokens {
+		if int64(token.UserID) != userID || token.RevokedAt != nil || (hash != "" && h != hash) {
+			continue
+		}
+
+		token.RevokedAt = &now
+		s.tokens[h] = token
+		revoked++
+	}
+
+	return revoked, nil
+}
+
+func (s *memoryStore) Update(ctx context.Context, user *userRecord, columns ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.users[int64(user.ID)]
+	if !ok || stored.DeletedAt.Valid || stored.Version != user.Version {
+		return errVersionConflict
+	}
+
+	for _, column := range columns {
+		switch column {
+		case "first_name":
+			stored.FirstName = user.FirstName
+		case "last_name":
+			stored.LastName = user.LastName
+		case "age":
+			stored.Age = user.Age
+		default:
+			return fmt.Errorf("cannot update column %q", column)
+		}
+	}
+
+	stored.Version++
+	stored.UpdatedAt = time.Now()
+	s.users[int64(user.ID)] = stored
+
+	user.Version = stored.Version
+	user.UpdatedAt = stored.UpdatedAt
+
+	return nil
+}
+
+func (s *memoryStore) Delete(ctx context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok || user.DeletedAt.Valid {
+		return errUserNotFound
+	}
+
+	user.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+	s.users[id] = user
+
+	return nil
+}
+
+func (s *memoryStore) List(ctx context.Context, q userQuery) ([]userRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	less := func(a, b userRecord) bool {
+		if c := compareUserColumn(a, b, q.OrderBy); c != 0 {
+			return (c < 0) != q.Desc
+		}
+		if c := cmp.Compare(a.ID, b.ID); c != 0 {
+			return (c < 0) != q.Desc
+		}
+		return false
+	}
+
+	cursor, hasCursor := s.users[q.After]
+
+	var users []userRecord
+
+	for _, user := range s.users {
+		if user.DeletedAt.Valid ||
+			(q.ID != 0 && int64(user.ID) != q.ID) ||
+			(q.LastName != "" && user.LastName != q.LastName) ||
+			(q.MinAge > 0 && user.Age < q.MinAge) ||
+			(q.MaxAge > 0 && user.Age > q.MaxAge) {
+			continue
+		}
+
+		if q.After != 0 && (!hasCursor || !less(cursor, user)) {
+			continue
+		}
+
+		users = append(users, user)
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		return less(users[i], users[j])
+	})
+
+	if q.Limit > 0 && len(users) > q.Limit {
+		users = users[:q.Limit]
+	}
+
+	return users, nil
+}
+
+func compareUserColumn(a, b userRecord, column string) int {
+	switch column {
+	case "first_name":
+		return cmp.Compare(a.FirstName, b.FirstName)
+	case "last_name":
+		return cmp.Compare(a.LastName, b.LastName)
+	case "age":
+		return cmp.Compare(a.Age, b.Age)
+	case "created_at":
+		return a.CreatedAt.Compare(b.CreatedAt)
+	default:
+		return 0
+	}
+}
+
+var _ UserStore = (*memoryStore)(nil)
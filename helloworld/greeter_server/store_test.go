@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	pb "google.golang.org/grpc/examples/helloworld/helloworld"
+	"google.golang.org/grpc/status"
+)
+
+// userStores runs a test against every UserStore that works without an
+// external database.
+func userStores(t *testing.T, test func(t *testing.T, store UserStore)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, newMemoryStore())
+	})
+
+	t.Run("sqlite", func(t *testing.T) {
+		test(t, newMigratedSQLiteStore(t))
+	})
+}
+
+func newMigratedSQLiteStore(t *testing.T) *gormStore {
+	store, err := newSQLiteStore(filepath.Join(t.TempDir(), "users.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+
+	m, err := newMigrator(store.db)
+	require.NoError(t, err)
+	_, err = m.Up(context.Background())
+	require.NoError(t, err)
+
+	return store
+}
+
+func TestUserStore_CreateGetDelete(t *testing.T) {
+	userStores(t, func(t *testing.T, store UserStore) {
+		ctx := context.Background()
+
+		user := &userRecord{FirstName: "Cool", LastName: "Kid", Age: 10, Version: 1, Tokens: []userToken{{TokenHash: "hash", IssuedAt: time.Now()}}}
+		require.NoError(t, store.Create(ctx, user))
+		assert.NotZero(t, user.ID)
+		assert.False(t, user.CreatedAt.IsZero())
+
+		got, err := store.Get(ctx, int64(user.ID))
+		require.NoError(t, err)
+		assert.Equal(t, "Cool", got.FirstName)
+		assert.Equal(t, int64(1), got.Version)
+
+		token, err := store.FindToken(ctx, "hash")
+		require.NoError(t, err)
+		assert.Equal(t, user.ID, token.UserID)
+		assert.Equal(t, user.Tokens[0].ID, token.ID)
+
+		_, err = store.FindToken(ctx, "other")
+		assert.ErrorIs(t, err, errTokenNotFound)
+
+		require.NoError(t, store.Delete(ctx, int64(user.ID)))
+
+		_, err = store.Get(ctx, int64(user.ID))
+		assert.ErrorIs(t, err, errUserNotFound)
+		_, err = store.FindToken(ctx, "hash")
+		assert.ErrorIs(t, err, errTokenNotFound)
+		assert.ErrorIs(t, store.Delete(ctx, int64(user.ID)), errUserNotFound)
+	})
+}
+
+func TestUserStore_RotateAndRevokeTokens(t *testing.T) {
+	userStores(t, func(t *testing.T, store UserStore) {
+		ctx := context.Background()
+		now := time.Now()
+
+		user := &userRecord{FirstName: "Cool", LastName: "Kid", Age: 10, Version: 1, Tokens: []userToken{{TokenHash: "first", IssuedAt: now}}}
+		require.NoError(t, store.Create(ctx, user))
+
+		graceEnd := now.Add(time.Hour)
+		second := &userToken{UserID: user.ID, TokenHash: "second", IssuedAt: now}
+		require.NoError(t, store.RotateToken(ctx, second, graceEnd))
+		assert.NotZero(t, second.ID)
+
+		first, err := store.FindToken(ctx, "first")
+		require.NoError(t, err)
+		require.NotNil(t, first.ExpiresAt)
+		assert.WithinDuration(t, graceEnd, *first.ExpiresAt, time.Second)
+		assert.True(t, first.active(now))
+		assert.False(t, first.active(graceEnd.Add(time.Second)))
+
+		revoked, err := store.RevokeTokens(ctx, int64(user.ID), "second")
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), revoked)
+
+		token, err := store.FindToken(ctx, "second")
+		require.NoError(t, err)
+		assert.False(t, token.active(now))
+
+		revoked, err = store.RevokeTokens(ctx, int64(user.ID), "")
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), revoked, "Expected only the unrevoked token to be revoked")
+
+		token, err = store.FindToken(ctx, "first")
+		require.NoError(t, err)
+		assert.NotNil(t, token.RevokedAt)
+	})
+}
+
+func TestUserStore_Username_IsUniqueAmongExistingUsers(t *testing.T) {
+	userStores(t, func(t *testing.T, store UserStore) {
+		ctx := context.Background()
+		username := "coolkid"
+
+		user := &userRecord{FirstName: "Cool", LastName: "Kid", Age: 10, Version: 1, Username: &username, PasswordHash: "hash"}
+		require.NoError(t, store.Create(ctx, user))
+		require.NoError(t, store.Create(ctx, &userRecord{FirstName: "No", LastName: "Name", Age: 10, Version: 1}))
+		require.NoError(t, store.Create(ctx, &userRecord{FirstName: "No", LastName: "Name", Age: 10, Version: 1}))
+
+		got, err := store.FindByUsername(ctx, "coolkid")
+		require.NoError(t, err)
+		assert.Equal(t, user.ID, got.ID)
+		assert.Equal(t, "hash", got.PasswordHash)
+
+		_, err = store.FindByUsername(ctx, "other")
+		assert.ErrorIs(t, err, errUserNotFound)
+
+		assert.ErrorIs(t, store.Create(ctx, &userRecord{FirstName: "Other", LastName: "Kid", Age: 10, Version: 1, Username: &username}), errUsernameTaken)
+
+		require.NoError(t, store.Delete(ctx, int64(user.ID)))
+		_, err = store.FindByUsername(ctx, "coolkid")
+		assert.ErrorIs(t, err, errUserNotFound)
+		assert.NoError(t, store.Create(ctx, &userRecord{FirstName: "Other", LastName: "Kid", Age: 10, Version: 1, Username: &username}), "Expected the username of a deleted user to be free")
+	})
+}
+
+func TestUserStore_CreateWithID_KeepsID(t *testing.T) {
+	userStores(t, func(t *testing.T, store UserStore) {
+		ctx := context.Background()
+
+		user := &userRecord{ID: 4242, FirstName: "Cool", LastName: "Kid", Age: 10, Version: 1}
+		require.NoError(t, store.Create(ctx, user))
+		assert.Equal(t, uint(4242), user.ID)
+
+		assert.Error(t, store.Create(ctx, &userRecord{ID: 4242, FirstName: "Other", LastName: "Kid", Age: 10, Version: 1}))
+	})
+}
+
+func TestUserStore_Update_ChecksVersion(t *testing.T) {
+	userStores(t, func(t *testing.T, store UserStore) {
+		ctx := context.Background()
+
+		user := &userRecord{FirstName: "Cool", LastName: "Kid", Age: 10, Version: 1}
+		require.NoError(t, store.Create(ctx, user))
+
+		stale := *user
+
+		user.Age = 11
+		user.FirstName = "Ignored"
+		require.NoError(t, store.Update(ctx, user, "age"))
+		assert.Equal(t, int64(2), user.Version)
+
+		got, err := store.Get(ctx, int64(user.ID))
+		require.NoError(t, err)
+		assert.Equal(t, int32(11), got.Age)
+		assert.Equal(t, "Cool", got.FirstName)
+		assert.Equal(t, int64(2), got.Version)
+
+		stale.Age = 12
+		assert.ErrorIs(t, store.Update(ctx, &stale, "age"), errVersionConflict)
+	})
+}
+
+func TestUserStore_List_FiltersOrdersAndPages(t *testing.T) {
+	userStores(t, func(t *testing.T, store UserStore) {
+		ctx := context.Background()
+
+		ages := []int32{30, 20, 40, 20, 50}
+		for i, age := range ages {
+			lastName := "Kid"
+			if i == 4 {
+				lastName = "Other"
+			}
+			require.NoError(t, store.Create(ctx, &userRecord{FirstName: "User", LastName: lastName, Age: age, Version: 1}))
+		}
+
+		users, err := store.List(ctx, userQuery{Limit: 10, OrderBy: "id"})
+		require.NoError(t, err)
+		assert.Len(t, users, 5)
+
+		users, err = store.List(ctx, userQuery{Limit: 10, OrderBy: "age", LastName: "Kid", MinAge: 20, MaxAge: 35})
+		require.NoError(t, err)
+		assert.Equal(t, []int32{20, 20, 30}, userAges(users))
+
+		first, err := store.List(ctx, userQuery{Limit: 2, OrderBy: "age", Desc: true})
+		require.NoError(t, err)
+		assert.Equal(t, []int32{50, 40}, userAges(first))
+
+		rest, err := store.List(ctx, userQuery{Limit: 10, OrderBy: "age", Desc: true, After: int64(first[1].ID)})
+		require.NoError(t, err)
+		assert.Equal(t, []int32{30, 20, 20}, userAges(rest))
+		assert.Greater(t, rest[1].ID, rest[2].ID)
+	})
+}
+
+func TestUserService_WithMemoryStore_CreateGetUpdateDelete(t *testing.T) {
+	ctx := context.Background()
+	server := &userServiceServer{Store: newMemoryStore()}
+	client := newAuthTestClient(t, server)
+
+	created, err := client.CreateUser(ctx, &pb.CreateUserRequest{
+		User: &pb.User{FirstName: "Cool", LastName: "Kid", Age: 10},
+	})
+	require.NoError(t, err)
+
+	id := created.User.Id
+
+	_, err = client.GetUser(ctx, &pb.GetUserRequest{Id: id, Token: "wrong"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	updated, err := client.UpdateUser(ctx, &pb.UpdateUserRequest{
+		Id:    id,
+		User:  &pb.User{FirstName: "Cooler", LastName: "Kid", Age: 11, Version: created.User.Version},
+		Token: created.Token,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), updated.User.Version)
+
+	_, err = client.UpdateUser(ctx, &pb.UpdateUserRequest{
+		Id:    id,
+		User:  &pb.User{FirstName: "Stale", LastName: "Kid", Age: 11, Version: created.User.Version},
+		Token: created.Token,
+	})
+	assert.Equal(t, codes.Aborted, status.Code(err))
+
+	got, err := client.GetUser(ctx, &pb.GetUserRequest{Id: id, Token: created.Token})
+	require.NoError(t, err)
+	assert.Equal(t, "Cooler", got.User.FirstName)
+
+	_, err = client.DeleteUser(ctx, &pb.DeleteUserRequest{Id: id, Token: created.Token})
+	require.NoError(t, err)
+
+	_, err = client.GetUser(ctx, &pb.GetUserRequest{Id: id, Token: created.Token})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), "Expected the token of a deleted user to be rejected")
+}
+
+func userAges(users []userRecord) []int32 {
+	ages := make([]int32, len(users))
+	for i, user := range users {
+		ages[i] = user.Age
+	}
+	return ages
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	pb "google.golang.org/grpc/examples/helloworld/helloworld"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestHashToken_IsKeyedByPepper(t *testing.T) {
+	hash := hashToken([]byte("pepper"), "token")
+
+	assert.Equal(t, hash, hashToken([]byte("pepper"), "token"))
+	assert.NotEqual(t, hash, hashToken([]byte("other"), "token"))
+	assert.NotContains(t, hash, "token")
+
+	assert.True(t, checkToken([]byte("pepper"), hash, "token"))
+	assert.False(t, checkToken([]byte("pepper"), hash, "wrong"))
+	assert.False(t, checkToken([]byte("other"), hash, "token"))
+	assert.False(t, checkToken([]byte("pepper"), "", ""))
+}
+
+func TestCreateUser_StoresOnlyTokenHash(t *testing.T) {
+	store := newMemoryStore()
+	server := &userServiceServer{Store: store, tokenPepper: []byte("pepper")}
+	ctx := context.Background()
+
+	resp, err := server.CreateUser(ctx, &pb.CreateUserRequest{User: &pb.User{FirstName: "Cool", LastName: "Kid", Age: 10}})
+	require.NoError(t, err)
+	require.NotEmpty(t, resp.Token)
+
+	hash := hashToken([]byte("pepper"), resp.Token)
+	record, err := store.FindToken(ctx, hash)
+	require.NoError(t, err)
+	assert.Equal(t, uint(resp.User.Id), record.UserID)
+
+	p, err := server.resolveToken(ctx, resp.Token)
+	require.NoError(t, err)
+	assert.Equal(t, resp.User.Id, p.UserID)
+
+	_, err = server.resolveToken(ctx, hash)
+	assert.EqualError(t, err, "rpc error: code = Unauthenticated desc = Unauthenticated")
+}
+
+func TestHashLegacyTokens_HashesPlaintextRows(t *testing.T) {
+	store := newMigratedSQLiteStore(t)
+	ctx := context.Background()
+	pepper := []byte("pepper")
+
+	require.NoError(t, store.db.Exec(`INSERT INTO users (first_name, last_name, age, token) VALUES ('Cool', 'Kid', 10, 'legacy')`).Error)
+
+	n, err := hashLegacyTokens(ctx, store, pepper)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	var token sql.NullString
+	require.NoError(t, store.db.Raw(`SELECT token FROM users WHERE id = 1`).Scan(&token).Error)
+	assert.False(t, token.Valid)
+
+	server := &userServiceServer{Store: store, tokenPepper: pepper}
+	p, err := server.resolveToken(ctx, "legacy")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), p.UserID)
+
+	n, err = hashLegacyTokens(ctx, store, pepper)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+}
+
+func TestRotateToken_OldTokenStopsAfterGracePeriod(t *testing.T) {
+	server := &userServiceServer{Store: newMemoryStore(), events: newUserEvents(10)}
+	client := newAuthTestClient(t, server)
+
+	created, err := client.CreateUser(context.Background(), &pb.CreateUserRequest{User: &pb.User{FirstName: "Cool", LastName: "Kid", Age: 10}})
+	require.NoError(t, err)
+
+	graceful, err := client.RotateToken(withBearer(created.Token), &pb.RotateTokenRequest{Id: created.User.Id, GracePeriod: durationpb.New(time.Hour)})
+	require.NoError(t, err)
+	assert.NotEqual(t, created.Token, graceful.Token)
+
+	_, err = client.GetUser(withBearer(created.Token), &pb.GetUserRequest{Id: created.User.Id})
+	assert.NoError(t, err, "Expected the old token to work during the grace period")
+
+	rotated, err := client.RotateToken(withBearer(graceful.Token), &pb.RotateTokenRequest{Id: created.User.Id})
+	require.NoError(t, err)
+
+	for _, old := range []string{created.Token, graceful.Token} {
+		_, err = client.GetUser(withBearer(old), &pb.GetUserRequest{Id: created.User.Id})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	}
+
+	_, err = client.GetUser(withBearer(rotated.Token), &pb.GetUserRequest{Id: created.User.Id})
+	assert.NoError(t, err)
+
+	_, err = client.RotateToken(withBearer(rotated.Token), &pb.RotateTokenRequest{Id: created.User.Id, GracePeriod: durationpb.New(-time.Second)})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestRevokeToken_RevokesOneOrAllTokens(t *testing.T) {
+	server := &userServiceServer{Store: newMemoryStore(), events: newUserEvents(10)}
+	client := newAuthTestClient(t, server)
+
+	created, err := client.CreateUser(context.Background(), &pb.CreateUserRequest{User: &pb.User{FirstName: "Cool", LastName: "Kid", Age: 10}})
+	require.NoError(t, err)
+	second, err := client.RotateToken(withBearer(created.Token), &pb.RotateTokenRequest{Id: created.User.Id, GracePeriod: durationpb.New(time.Hour)})
+	require.NoError(t, err)
+
+	resp, err := client.RevokeToken(withBearer(second.Token), &pb.RevokeTokenRequest{Id: created.User.Id, TokenToRevoke: created.Token})
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), resp.Revoked)
+
+	_, err = client.GetUser(withBearer(created.Token), &pb.GetUserRequest{Id: created.User.Id})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = client.RevokeToken(withBearer(second.Token), &pb.RevokeTokenRequest{Id: created.User.Id, TokenToRevoke: "unknown"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = client.RevokeToken(withBearer(second.Token), &pb.RevokeTokenRequest{Id: created.User.Id})
+	require.NoError(t, err)
+
+	_, err = client.GetUser(withBearer(second.Token), &pb.GetUserRequest{Id: created.User.Id})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestResolveToken_ExpiredToken_IsRejected(t *testing.T) {
+	server := &userServiceServer{Store: newMemoryStore(), tokenTTL: time.Hour}
+	ctx := context.Background()
+
+	created, err := server.CreateUser(ctx, &pb.CreateUserRequest{User: &pb.User{FirstName: "Cool", LastName: "Kid", Age: 10}})
+	require.NoError(t, err)
+	require.NotNil(t, created.TokenExpiresAt)
+
+	expired, record := server.newUserToken(uint(created.User.Id), time.Now().Add(-2*time.Hour))
+	require.NoError(t, server.Store.AddToken(ctx, &record))
+
+	_, err = server.resolveToken(ctx, created.Token)
+	assert.NoError(t, err)
+
+	_, err = server.resolveToken(ctx, expired)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	pb "google.golang.org/grpc/examples/helloworld/helloworld"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestTracing_SpansRPCAndQueriesInOneTrace(t *testing.T) {
+	store := newMigratedSQLiteStore(t)
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	listener := bufconn.Listen(1 << 20)
+	grpcServer := grpc.NewServer(grpc.StatsHandler(otelgrpc.NewServerHandler()))
+	pb.RegisterUserServiceServer(grpcServer, &userServiceServer{Store: store})
+	go grpcServer.Serve(listener)
+	defer grpcServer.Stop()
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	ctx, root := provider.Tracer("test").Start(context.Background(), "PUT /user/{id}")
+	_, err = pb.NewUserServiceClient(conn).CreateUser(ctx, &pb.CreateUserRequest{
+		User: &pb.User{FirstName: "Cool", LastName: "Kid", Age: 10},
+	})
+	require.NoError(t, err)
+	root.End()
+
+	names := map[string]bool{}
+	for _, span := range recorder.Ended() {
+		assert.Equal(t, root.SpanContext().TraceID(), span.SpanContext().TraceID(), span.Name())
+		names[span.Name()] = true
+	}
+
+	assert.True(t, names["helloworld.UserService/CreateUser"])
+	assert.True(t, names["insert users"], names)
+}
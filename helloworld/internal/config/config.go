@@ -0,0 +1,203 @@
+// Package config layers command-line flags over environment variables over
+// an optional YAML file, so every setting of a binary is declared once as a
+// flag.
+//
+// For a flag named "db-password" and the prefix "GREETER_SERVER" the value is
+// taken from, in order:
+//
+//	-db-password on the command line
+//	GREETER_SERVER_DB_PASSWORD
+//	the contents of the file named by GREETER_SERVER_DB_PASSWORD_FILE
+//	db-password in the config file
+//	the contents of the file named by db-password-file in the config file
+//	the flag default
+//
+// The config file is named by -config or <PREFIX>_CONFIG.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileFlag is the flag naming the optional YAML config file.
+const FileFlag = "config"
+
+// Load parses args into fs and fills every flag not given on the command line
+// from the environment or the config file.
+func Load(fs *flag.FlagSet, args []string, envPrefix string) error {
+	if fs.Lookup(FileFlag) == nil {
+		fs.String(FileFlag, "", "optional YAML file with settings keyed by flag name")
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	env := func(name string) (string, bool, error) {
+		key := envName(envPrefix, name)
+		if value, ok := os.LookupEnv(key); ok {
+			return value, true, nil
+		}
+		if path, ok := os.LookupEnv(key + "_FILE"); ok {
+			value, err := readSecret(path)
+			return value, true, err
+		}
+		return "", false, nil
+	}
+
+	if !set[FileFlag] {
+		value, ok, err := env(FileFlag)
+		if err != nil {
+			return err
+		}
+		if ok {
+			if err := fs.Set(FileFlag, value); err != nil {
+				return err
+			}
+		}
+	}
+
+	file, err := readFile(fs.Lookup(FileFlag).Value.String())
+	if err != nil {
+		return err
+	}
+
+	for key := range file {
+		name := strings.TrimSuffix(key, "-file")
+		if fs.Lookup(key) == nil && (name == key || fs.Lookup(name) == nil) {
+			return fmt.Errorf("config file: unknown setting %q", key)
+		}
+	}
+
+	var errs []error
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] || f.Name == FileFlag {
+			return
+		}
+
+		value, ok, err := env(f.Name)
+		if !ok && err == nil {
+			value, ok = file[f.Name]
+		}
+		if !ok && err == nil {
+			var path string
+			if path, ok = file[f.Name+"-file"]; ok {
+				value, err = readSecret(path)
+			}
+		}
+
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+
+		if ok {
+			if err := f.Value.Set(value); err != nil {
+				errs = append(errs, fmt.Errorf("invalid value %q for %s: %w", value, f.Name, err))
+			}
+		}
+	})
+
+	return errors.Join(errs...)
+}
+
+// Print writes the effective settings as a YAML config file, replacing the
+// values of the named secret flags.
+func Print(w io.Writer, fs *flag.FlagSet, secrets ...string) error {
+	hidden := map[string]bool{FileFlag: true, "print-config": true}
+
+	values := map[string]string{}
+	fs.VisitAll(func(f *flag.Flag) {
+		if hidden[f.Name] {
+			return
+		}
+		values[f.Name] = f.Value.String()
+	})
+
+	for _, name := range secrets {
+		if values[name] != "" {
+			values[name] = "REDACTED"
+		}
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	doc := &yaml.Node{Kind: yaml.MappingNode}
+	for _, name := range names {
+		doc.Content = append(doc.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Value: name},
+			&yaml.Node{Kind: yaml.ScalarNode, Value: values[name]},
+		)
+	}
+
+	enc := yaml.NewEncoder(w)
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+func envName(prefix, name string) string {
+	name = strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+	if prefix == "" {
+		return name
+	}
+	return prefix + "_" + name
+}
+
+func readFile(path string) (map[string]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]interface{}
+	if err := yaml.NewDecoder(bytes.NewReader(data)).Decode(&raw); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	file := make(map[string]string, len(raw))
+	for key, value := range raw {
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			return nil, fmt.Errorf("config file %s: %s must be a single value", path, key)
+		case nil:
+			file[key] = ""
+		default:
+			file[key] = fmt.Sprint(value)
+		}
+	}
+
+	return file, nil
+}
+
+func readSecret(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
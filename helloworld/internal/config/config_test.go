@@ -0,0 +1,90 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type settings struct {
+	addr     *string
+	port     *int
+	password *string
+	window   *time.Duration
+}
+
+func newFlagSet() (*flag.FlagSet, settings) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	return fs, settings{
+		addr:     fs.String("addr", "default:1", ""),
+		port:     fs.Int("db-port", 5432, ""),
+		password: fs.String("db-password", "", ""),
+		window:   fs.Duration("window", time.Hour, ""),
+	}
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoad_FlagsOverrideEnvOverrideFile(t *testing.T) {
+	file := writeFile(t, "config.yaml", "addr: file:1\ndb-port: 6000\nwindow: 2h\n")
+
+	t.Setenv("TEST_CONFIG", file)
+	t.Setenv("TEST_DB_PORT", "7000")
+
+	fs, s := newFlagSet()
+	require.NoError(t, Load(fs, []string{"-addr", "flag:1"}, "TEST"))
+
+	assert.Equal(t, "flag:1", *s.addr)
+	assert.Equal(t, 7000, *s.port)
+	assert.Equal(t, 2*time.Hour, *s.window)
+	assert.Equal(t, "", *s.password)
+}
+
+func TestLoad_ReadsSecretsFromFiles(t *testing.T) {
+	secret := writeFile(t, "password", "s3cret\n")
+
+	t.Setenv("TEST_DB_PASSWORD_FILE", secret)
+
+	fs, s := newFlagSet()
+	require.NoError(t, Load(fs, nil, "TEST"))
+	assert.Equal(t, "s3cret", *s.password)
+
+	file := writeFile(t, "config.yaml", "db-password-file: "+secret+"\n")
+	os.Unsetenv("TEST_DB_PASSWORD_FILE")
+
+	fs, s = newFlagSet()
+	require.NoError(t, Load(fs, []string{"-config", file}, "TEST"))
+	assert.Equal(t, "s3cret", *s.password)
+}
+
+func TestLoad_RejectsBadInput(t *testing.T) {
+	fs, _ := newFlagSet()
+	assert.Error(t, Load(fs, []string{"-config", writeFile(t, "c.yaml", "unknown: 1\n")}, "TEST"))
+
+	fs, _ = newFlagSet()
+	assert.Error(t, Load(fs, []string{"-config", writeFile(t, "c.yaml", "addr:\n  nested: 1\n")}, "TEST"))
+
+	t.Setenv("TEST_DB_PORT", "not-a-number")
+	fs, _ = newFlagSet()
+	assert.Error(t, Load(fs, nil, "TEST"))
+}
+
+func TestPrint_RedactsSecrets(t *testing.T) {
+	fs, _ := newFlagSet()
+	require.NoError(t, Load(fs, []string{"-db-password", "s3cret"}, "TEST"))
+
+	var out bytes.Buffer
+	require.NoError(t, Print(&out, fs, "db-password"))
+
+	assert.Equal(t, "addr: default:1\ndb-password: REDACTED\ndb-port: 5432\nwindow: 1h0m0s\n", out.String())
+}
@@ -0,0 +1,47 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew_AddsRequestIDFromContext(t *testing.T) {
+	var out bytes.Buffer
+
+	logger, err := New(&out, "json", "info")
+	require.NoError(t, err)
+
+	ctx := WithRequestID(context.Background(), "req-1")
+	logger.With("component", "test").InfoContext(ctx, "handled", "code", "OK")
+	logger.DebugContext(ctx, "hidden")
+
+	var record map[string]interface{}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &record))
+
+	assert.Equal(t, "handled", record["msg"])
+	assert.Equal(t, "req-1", record["request_id"])
+	assert.Equal(t, "test", record["component"])
+	assert.Equal(t, 1, strings.Count(out.String(), "\n"))
+}
+
+func TestNew_RejectsUnknownSettings(t *testing.T) {
+	_, err := New(&bytes.Buffer{}, "xml", "info")
+	assert.Error(t, err)
+
+	_, err = New(&bytes.Buffer{}, "text", "loud")
+	assert.Error(t, err)
+}
+
+func TestRequestIDOrNew(t *testing.T) {
+	assert.Equal(t, "abc-123", RequestIDOrNew("abc-123"))
+	assert.NotEmpty(t, RequestIDOrNew(""))
+	assert.NotEqual(t, "bad id", RequestIDOrNew("bad id"))
+	assert.NotEqual(t, "inject\nline", RequestIDOrNew("inject\nline"))
+	assert.Len(t, RequestIDOrNew(strings.Repeat("a", 200)), 36)
+}
@@ -0,0 +1,239 @@
+// Package tlsconfig builds TLS configurations for the user server and the
+// gateway from PEM files, picking up renewed certificates without a restart.
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Files names the PEM files of one side of a connection. Cert and Key are
+// either both set or both empty; CA is the pool used to verify the peer.
+type Files struct {
+	Cert string
+	Key  string
+	CA   string
+}
+
+func (f Files) Validate() error {
+	if (f.Cert == "") != (f.Key == "") {
+		return errors.New("a TLS certificate and key must be given together")
+	}
+	return nil
+}
+
+// Reloader holds the certificate and CA pool loaded from Files and reloads
+// them when any of the files changes on disk.
+type Reloader struct {
+	files Files
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	pool     *x509.CertPool
+	modTimes map[string]time.Time
+}
+
+func NewReloader(files Files) (*Reloader, error) {
+	if err := files.Validate(); err != nil {
+		return nil, err
+	}
+
+	r := &Reloader{files: files}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Watch checks the files every interval and reloads them after a change
+// until ctx is done. A failed reload keeps the previous certificates.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if !r.changed() {
+			continue
+		}
+
+		if err := r.reload(); err != nil {
+			slog.Error("Failed to reload TLS certificates", "err", err)
+			continue
+		}
+
+		slog.Info("reloaded TLS certificates")
+	}
+}
+
+func (r *Reloader) paths() []string {
+	var paths []string
+	for _, path := range []string{r.files.Cert, r.files.Key, r.files.CA} {
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+func (r *Reloader) changed() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, path := range r.paths() {
+		info, err := os.Stat(path)
+		if err != nil || !info.ModTime().Equal(r.modTimes[path]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *Reloader) reload() error {
+	modTimes := map[string]time.Time{}
+	for _, path := range r.paths() {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		modTimes[path] = info.ModTime()
+	}
+
+	var cert *tls.Certificate
+	if r.files.Cert != "" {
+		c, err := tls.LoadX509KeyPair(r.files.Cert, r.files.Key)
+		if err != nil {
+			return err
+		}
+		cert = &c
+	}
+
+	var pool *x509.CertPool
+	if r.files.CA != "" {
+		pem, err := os.ReadFile(r.files.CA)
+		if err != nil {
+			return err
+		}
+
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", r.files.CA)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cert, r.pool, r.modTimes = cert, pool, modTimes
+
+	return nil
+}
+
+func (r *Reloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.cert, r.pool
+}
+
+// ServerConfig serves the current certificate. With a CA the server requires
+// client certificates signed by it; allowedClients, when not empty, further
+// limits them to certificates whose common name or a DNS name is listed.
+func (r *Reloader) ServerConfig(allowedClients []string) (*tls.Config, error) {
+	if r.files.Cert == "" {
+		return nil, errors.New("the server needs a TLS certificate")
+	}
+
+	if len(allowedClients) > 0 && r.files.CA == "" {
+		return nil, errors.New("client identity checks need a client CA")
+	}
+
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, pool := r.current()
+
+			config := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+			}
+
+			if pool != nil {
+				config.ClientAuth = tls.RequireAndVerifyClientCert
+				config.ClientCAs = pool
+				config.VerifyConnection = func(cs tls.ConnectionState) error {
+					return checkIdentity(cs.PeerCertificates[0], allowedClients)
+				}
+			}
+
+			return config, nil
+		},
+	}, nil
+}
+
+// ClientConfig presents the current certificate, if any, and verifies the
+// server against the current CA pool, or the system roots without one.
+func (r *Reloader) ClientConfig(serverName string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := r.current()
+			if cert == nil {
+				return &tls.Certificate{}, nil
+			}
+			return cert, nil
+		},
+		// Verification is done in VerifyConnection so a reloaded CA pool
+		// applies to new connections.
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			_, pool := r.current()
+
+			opts := x509.VerifyOptions{
+				Roots:         pool,
+				DNSName:       cs.ServerName,
+				Intermediates: x509.NewCertPool(),
+			}
+			for _, cert := range cs.PeerCertificates[1:] {
+				opts.Intermediates.AddCert(cert)
+			}
+
+			_, err := cs.PeerCertificates[0].Verify(opts)
+			return err
+		},
+	}
+}
+
+func checkIdentity(cert *x509.Certificate, allowed []string) error {
+	if len(allowed) == 0 {
+		return nil
+	}
+
+	if slices.Contains(allowed, cert.Subject.CommonName) {
+		return nil
+	}
+
+	for _, name := range cert.DNSNames {
+		if slices.Contains(allowed, name) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("client certificate %q is not allowed", cert.Subject.CommonName)
+}
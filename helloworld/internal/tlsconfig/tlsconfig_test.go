@@ -0,0 +1,195 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type authority struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newAuthority(t *testing.T) *authority {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &authority{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue writes a certificate for name signed by ca and returns its files.
+func (ca *authority) issue(t *testing.T, dir, name string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+
+	return certFile, keyFile
+}
+
+func (ca *authority) write(t *testing.T, dir string) string {
+	path := filepath.Join(dir, "ca.crt")
+	require.NoError(t, os.WriteFile(path, ca.pem, 0o600))
+	return path
+}
+
+func handshake(t *testing.T, server, client *tls.Config) (*x509.Certificate, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			serverErr <- err
+			return
+		}
+		defer conn.Close()
+
+		serverErr <- tls.Server(conn, server).Handshake()
+	}()
+
+	rawConn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer rawConn.Close()
+
+	conn := tls.Client(rawConn, client)
+	err = conn.Handshake()
+	if err == nil {
+		// TLS 1.3 clients finish before the server has checked their
+		// certificate, so wait for its verdict.
+		err = <-serverErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return conn.ConnectionState().PeerCertificates[0], nil
+}
+
+func TestMutualTLS_ChecksClientIdentity(t *testing.T) {
+	dir := t.TempDir()
+	ca := newAuthority(t)
+	caFile := ca.write(t, dir)
+
+	serverCert, serverKey := ca.issue(t, dir, "users.internal")
+	gatewayCert, gatewayKey := ca.issue(t, dir, "gateway")
+	otherCert, otherKey := ca.issue(t, dir, "other")
+
+	server, err := NewReloader(Files{Cert: serverCert, Key: serverKey, CA: caFile})
+	require.NoError(t, err)
+
+	serverConfig, err := server.ServerConfig([]string{"gateway"})
+	require.NoError(t, err)
+
+	gateway, err := NewReloader(Files{Cert: gatewayCert, Key: gatewayKey, CA: caFile})
+	require.NoError(t, err)
+
+	peer, err := handshake(t, serverConfig, gateway.ClientConfig("users.internal"))
+	require.NoError(t, err)
+	assert.Equal(t, "users.internal", peer.Subject.CommonName)
+
+	other, err := NewReloader(Files{Cert: otherCert, Key: otherKey, CA: caFile})
+	require.NoError(t, err)
+
+	_, err = handshake(t, serverConfig, other.ClientConfig("users.internal"))
+	assert.Error(t, err)
+
+	_, err = handshake(t, serverConfig, gateway.ClientConfig("wrong.name"))
+	assert.Error(t, err)
+
+	anonymous, err := NewReloader(Files{CA: caFile})
+	require.NoError(t, err)
+
+	_, err = handshake(t, serverConfig, anonymous.ClientConfig("users.internal"))
+	assert.Error(t, err)
+}
+
+func TestReloader_PicksUpRenewedCertificates(t *testing.T) {
+	dir := t.TempDir()
+	oldCA := newAuthority(t)
+	certFile, keyFile := oldCA.issue(t, dir, "users.internal")
+
+	server, err := NewReloader(Files{Cert: certFile, Key: keyFile})
+	require.NoError(t, err)
+	serverConfig, err := server.ServerConfig(nil)
+	require.NoError(t, err)
+
+	newCA := newAuthority(t)
+	client, err := NewReloader(Files{CA: newCA.write(t, dir)})
+	require.NoError(t, err)
+
+	_, err = handshake(t, serverConfig, client.ClientConfig("users.internal"))
+	assert.Error(t, err)
+
+	newCA.issue(t, dir, "users.internal")
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, future, future))
+
+	assert.True(t, server.changed())
+	require.NoError(t, server.reload())
+	assert.False(t, server.changed())
+
+	_, err = handshake(t, serverConfig, client.ClientConfig("users.internal"))
+	assert.NoError(t, err)
+}
+
+func TestFiles_Validate(t *testing.T) {
+	assert.NoError(t, Files{}.Validate())
+	assert.NoError(t, Files{Cert: "a", Key: "b"}.Validate())
+	assert.Error(t, Files{Cert: "a"}.Validate())
+
+	_, err := NewReloader(Files{CA: "missing.crt"})
+	assert.Error(t, err)
+}
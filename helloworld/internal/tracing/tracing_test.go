@@ -0,0 +1,37 @@
+package tracing
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	assert.NoError(t, Config{Exporter: "none", SampleRatio: 1}.Validate())
+	assert.NoError(t, Config{Exporter: "otlp", SampleRatio: 0.5}.Validate())
+	assert.Error(t, Config{Exporter: "file", SampleRatio: 1}.Validate())
+	assert.Error(t, Config{Exporter: "jaeger", SampleRatio: 1}.Validate())
+	assert.Error(t, Config{Exporter: "stdout", SampleRatio: 2}.Validate())
+}
+
+func TestSetup_FileExporterWritesSpans(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.json")
+
+	shutdown, err := Setup(context.Background(), "test", Config{Exporter: "file", File: path, SampleRatio: 1})
+	require.NoError(t, err)
+
+	_, span := otel.Tracer("test").Start(context.Background(), "PUT /user/{id}")
+	span.End()
+
+	require.NoError(t, shutdown(context.Background()))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"Name":"PUT /user/{id}"`)
+	assert.Contains(t, string(data), `"Value":"test"`)
+}